@@ -0,0 +1,79 @@
+package cosmwasm
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// codeCache is an in-process LRU cache of Wasm code by checksum, limited by the total code size.
+// Since code is content addressed, entries only become stale when the code is removed (see remove).
+type codeCache struct {
+	mutex    sync.Mutex
+	capacity uint64
+	size     uint64
+	entries  map[string]*list.Element
+	// order contains the entries with the most recently used at the front
+	order *list.List
+}
+
+type codeCacheEntry struct {
+	checksum string
+	code     []byte
+}
+
+func newCodeCache(capacity uint64) *codeCache {
+	return &codeCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns a copy of the cached code for checksum
+func (c *codeCache) get(checksum Checksum) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[string(checksum)]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return bytes.Clone(elem.Value.(*codeCacheEntry).code), true
+}
+
+// add inserts a copy of code, evicting the least recently used entries as needed.
+// Code larger than the capacity is not cached.
+func (c *codeCache) add(checksum Checksum, code []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if uint64(len(code)) > c.capacity {
+		return
+	}
+	if _, ok := c.entries[string(checksum)]; ok {
+		return
+	}
+	for c.size+uint64(len(code)) > c.capacity {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*codeCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.checksum)
+		c.size -= uint64(len(entry.code))
+	}
+	entry := &codeCacheEntry{checksum: string(checksum), code: bytes.Clone(code)}
+	c.entries[entry.checksum] = c.order.PushFront(entry)
+	c.size += uint64(len(code))
+}
+
+// remove drops the code for checksum from the cache, if present
+func (c *codeCache) remove(checksum Checksum) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.entries[string(checksum)]
+	if !ok {
+		return
+	}
+	c.order.Remove(elem)
+	delete(c.entries, string(checksum))
+	c.size -= uint64(len(elem.Value.(*codeCacheEntry).code))
+}
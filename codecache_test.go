@@ -0,0 +1,94 @@
+package cosmwasm
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/types"
+)
+
+func TestCodeCacheEviction(t *testing.T) {
+	cache := newCodeCache(10)
+	cache.add(Checksum("a"), []byte("aaaa"))
+	cache.add(Checksum("b"), []byte("bbbb"))
+	// too large
+	cache.add(Checksum("c"), []byte("ccccccccccc"))
+	_, ok := cache.get(Checksum("c"))
+	require.False(t, ok)
+
+	// use a, such that b is evicted
+	code, ok := cache.get(Checksum("a"))
+	require.True(t, ok)
+	require.Equal(t, []byte("aaaa"), code)
+	cache.add(Checksum("d"), []byte("dddd"))
+	_, ok = cache.get(Checksum("b"))
+	require.False(t, ok)
+	_, ok = cache.get(Checksum("a"))
+	require.True(t, ok)
+	_, ok = cache.get(Checksum("d"))
+	require.True(t, ok)
+	require.Equal(t, uint64(8), cache.size)
+
+	// returned code is a copy
+	code[0] = 'x'
+	code, _ = cache.get(Checksum("a"))
+	require.Equal(t, []byte("aaaa"), code)
+
+	cache.remove(Checksum("a"))
+	_, ok = cache.get(Checksum("a"))
+	require.False(t, ok)
+	require.Equal(t, uint64(4), cache.size)
+	// removing again is a no-op
+	cache.remove(Checksum("a"))
+	require.Equal(t, uint64(4), cache.size)
+}
+
+func TestCodeReadCache(t *testing.T) {
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		CodeReadCacheMiB:  1,
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	code, err := vm.GetCode(checksum)
+	require.NoError(t, err)
+
+	// served from memory even when the file is gone
+	err = os.Remove(filepath.Join(vm.dataDir, "state", "wasm", hex.EncodeToString(checksum)))
+	require.NoError(t, err)
+	cached, err := vm.GetCode(checksum)
+	require.NoError(t, err)
+	require.Equal(t, code, cached)
+}
+
+func BenchmarkGetCode(b *testing.B) {
+	for name, cacheMiB := range map[string]uint32{"disk": 0, "cached": 1} {
+		b.Run(name, func(b *testing.B) {
+			tmpdir := b.TempDir()
+			vm, err := NewVMWithConfig(types.VMConfig{
+				DataDir:           tmpdir,
+				SupportedFeatures: TESTING_FEATURES,
+				MemoryLimit:       TESTING_MEMORY_LIMIT,
+				CacheSize:         TESTING_CACHE_SIZE,
+				CodeReadCacheMiB:  cacheMiB,
+			})
+			require.NoError(b, err)
+			defer vm.Cleanup()
+			wasm, err := os.ReadFile(HACKATOM_TEST_CONTRACT)
+			require.NoError(b, err)
+			checksum, err := vm.Create(wasm)
+			require.NoError(b, err)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := vm.GetCode(checksum)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
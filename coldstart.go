@@ -0,0 +1,88 @@
+package cosmwasm
+
+import (
+	"os"
+	"time"
+
+	"github.com/Finschia/wasmvm/types"
+)
+
+// ColdStartCost measures instantiating the contract with the given checksum without and with
+// the compiled module being cached, which helps to decide which contracts to pin.
+//
+// libwasmvm cannot evict single modules from its caches, so the code is copied into a temporary cache
+// with the same configuration, in which it is instantiated cold (compiled from the Wasm code) and then warm
+// (served from the in-memory cache). The caches of the VM itself are not changed, and the AuditSink and
+// OnCacheSoftLimit callbacks are not called. Writes to the store are discarded like in SimulateInstantiate.
+//
+// Compiling is not charged any gas, so the cost of a cold start is the difference of the durations
+// in the report rather than of the gas.
+func (vm *VM) ColdStartCost(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	initMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.ColdStartReport, error) {
+	code, err := vm.GetCode(checksum)
+	if err != nil {
+		return nil, err
+	}
+	tmpdir, err := os.MkdirTemp("", "wasmvm-coldstart")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+	tmp, err := NewVMWithConfig(vm.coldStartConfig(tmpdir))
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Cleanup()
+	tmp.SetDefaultGasLimit(vm.defaultGasLimit.Load())
+	// the code was validated when it was stored in vm. This only stores the Wasm, such that the
+	// module is compiled by the first instantiation.
+	if _, err := tmp.StoreCodeUnchecked(code); err != nil {
+		return nil, err
+	}
+
+	var report types.ColdStartReport
+	// measure runs one instantiation and returns its gas, duration and the metrics afterwards
+	measure := func(gasUsed *uint64, duration *time.Duration, metrics *types.Metrics) error {
+		start := vm.clock()
+		_, gas, err := tmp.SimulateInstantiate(checksum, env, info, initMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+		*duration = vm.clock().Sub(start)
+		*gasUsed = gas
+		if err != nil {
+			return err
+		}
+		m, err := tmp.GetMetrics()
+		if err != nil {
+			return err
+		}
+		*metrics = *m
+		return nil
+	}
+	if err := measure(&report.ColdGas, &report.ColdDuration, &report.ColdMetrics); err != nil {
+		return nil, err
+	}
+	if err := measure(&report.WarmGas, &report.WarmDuration, &report.WarmMetrics); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// coldStartConfig returns the configuration of the temporary VM used by ColdStartCost, which is
+// the configuration of vm with the given data directory. The callbacks are not copied, since the
+// calls of the temporary VM are measurements and must not be reported as calls of vm.
+func (vm *VM) coldStartConfig(dataDir string) types.VMConfig {
+	config := vm.config
+	config.DataDir = dataDir
+	config.AuditSink = nil
+	config.OnCacheSoftLimit = nil
+	return config
+}
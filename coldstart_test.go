@@ -0,0 +1,78 @@
+package cosmwasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+func TestColdStartCost(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	before, err := vm.GetMetrics()
+	require.NoError(t, err)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	report, err := vm.ColdStartCost(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.NotZero(t, report.WarmGas)
+	require.GreaterOrEqual(t, report.ColdGas, report.WarmGas)
+	// compiling is not charged
+	require.Equal(t, report.ColdGas, report.WarmGas)
+	// the cold run compiled the module, the warm one used the memory cache
+	require.Equal(t, uint32(1), report.ColdMetrics.Misses)
+	require.Equal(t, uint32(0), report.ColdMetrics.HitsMemoryCache+report.ColdMetrics.HitsFsCache)
+	require.Equal(t, uint32(1), report.WarmMetrics.Misses)
+	require.Equal(t, uint32(1), report.WarmMetrics.HitsMemoryCache)
+
+	// neither the store nor the caches of the VM are changed
+	iter := store.Iterator(nil, nil)
+	require.False(t, iter.Valid())
+	iter.Close()
+	after, err := vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	// unknown code
+	_, err = vm.ColdStartCost(Checksum("unknown"), env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.Error(t, err)
+}
+
+func TestColdStartConfig(t *testing.T) {
+	config := types.VMConfig{
+		SupportedFeatures:    TESTING_FEATURES,
+		MemoryLimit:          TESTING_MEMORY_LIMIT,
+		CacheSize:            TESTING_CACHE_SIZE,
+		MaxMemoryPages:       TESTING_MEMORY_LIMIT * wasmPagesPerMiB,
+		EntryPointGas:        types.EntryPointGas{Instantiate: 1000},
+		IteratorGasCosts:     types.IteratorGasCosts{Scan: 1, Next: 2},
+		StrictJSON:           true,
+		MaxEventsPerResponse: 3,
+		StrictSubMessages:    true,
+		CacheSoftLimitRatio:  0.9,
+		OnCacheSoftLimit:     func(used, capacity uint64) {},
+		AuditSink:            func(entry types.AuditEntry) {},
+		CodeChunkSize:        1024,
+	}
+	vm := withVMConfig(t, config)
+
+	// all options are copied except for the data directory and the callbacks
+	tmp := vm.coldStartConfig("tmp")
+	require.Equal(t, "tmp", tmp.DataDir)
+	require.Nil(t, tmp.OnCacheSoftLimit)
+	require.Nil(t, tmp.AuditSink)
+	config.DataDir = "tmp"
+	config.OnCacheSoftLimit = nil
+	config.AuditSink = nil
+	require.Equal(t, config, tmp)
+}
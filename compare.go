@@ -0,0 +1,92 @@
+package cosmwasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+// compareGasLimit is the gas limit of each call made by CompareVMs
+const compareGasLimit = 500_000_000_000
+
+// CompareVMs runs the same workload on two VMs and returns an error describing all differences
+// in gas usage and results. This is meant for checking determinism between VM instances, e.g.
+// of different library versions. The code for checksum must be stored in both VMs.
+//
+// The first message is used to instantiate the contract, all others are executed in order.
+// Each VM uses its own fresh mock store, API and querier.
+func CompareVMs(a, b *VM, checksum Checksum, msgs [][]byte) error {
+	if len(msgs) == 0 {
+		return fmt.Errorf("no messages to compare")
+	}
+	runA := newCompareRunner(a, checksum)
+	runB := newCompareRunner(b, checksum)
+
+	var diffs []string
+	for i, msg := range msgs {
+		resA, gasA, errA := runA.call(i == 0, msg)
+		resB, gasB, errB := runB.call(i == 0, msg)
+		if gasA != gasB {
+			diffs = append(diffs, fmt.Sprintf("message %d: gas used %d != %d", i, gasA, gasB))
+		}
+		if resA != resB {
+			diffs = append(diffs, fmt.Sprintf("message %d: result %s != %s", i, resA, resB))
+		}
+		if errA != errB {
+			diffs = append(diffs, fmt.Sprintf("message %d: error %q != %q", i, errA, errB))
+		}
+	}
+	if len(diffs) != 0 {
+		return fmt.Errorf("VMs differ:\n%s", strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+type compareRunner struct {
+	vm       *VM
+	checksum Checksum
+	store    *api.Lookup
+	goapi    *GoAPI
+	querier  Querier
+}
+
+func newCompareRunner(vm *VM, checksum Checksum) *compareRunner {
+	return &compareRunner{
+		vm:       vm,
+		checksum: checksum,
+		store:    api.NewLookup(api.NewMockGasMeter(compareGasLimit)),
+		goapi:    api.NewMockAPI(),
+		querier:  api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil),
+	}
+}
+
+// call instantiates or executes the contract and returns the JSON encoded response, the gas used
+// (by the contract and the store) and the error message
+func (r *compareRunner) call(instantiate bool, msg []byte) (string, uint64, string) {
+	gasMeter := api.NewMockGasMeter(compareGasLimit)
+	r.store.SetGasMeter(gasMeter)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+
+	var res *types.Response
+	var gasUsed uint64
+	var err error
+	if instantiate {
+		res, gasUsed, err = r.vm.Instantiate(r.checksum, env, info, msg, r.store, *r.goapi, r.querier, gasMeter, compareGasLimit, deserCost)
+	} else {
+		res, gasUsed, err = r.vm.Execute(r.checksum, env, info, msg, r.store, *r.goapi, r.querier, gasMeter, compareGasLimit, deserCost)
+	}
+	gasUsed += gasMeter.GasConsumed()
+	if err != nil {
+		return "", gasUsed, err.Error()
+	}
+	bz, err := json.Marshal(res)
+	if err != nil {
+		return "", gasUsed, err.Error()
+	}
+	return string(bz), gasUsed, ""
+}
@@ -0,0 +1,35 @@
+package cosmwasm
+
+import (
+	"testing"
+
+	"github.com/Finschia/wasmvm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVMs(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	msgs := [][]byte{
+		[]byte(`{"verifier": "creator", "beneficiary": "bob"}`),
+		[]byte(`{"release":{}}`),
+		[]byte(`{"panic":{}}`),
+	}
+	err := CompareVMs(vm, vm, checksum, msgs)
+	require.NoError(t, err)
+
+	// different config leads to different gas
+	other := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		EntryPointGas:     types.EntryPointGas{Execute: 1},
+	})
+	createTestContract(t, other, HACKATOM_TEST_CONTRACT)
+	err = CompareVMs(vm, other, checksum, msgs)
+	require.ErrorContains(t, err, "message 1: gas used")
+
+	err = CompareVMs(vm, vm, checksum, nil)
+	require.Error(t, err)
+}
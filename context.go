@@ -0,0 +1,158 @@
+package cosmwasm
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+// The *WithContext methods abort the contract when the context is done. libwasmvm cannot be
+// interrupted from the outside, so the context is checked whenever the contract calls into the
+// host (storage, address conversion and queries). A contract that loops without calling into
+// the host only stops when it runs out of gas.
+//
+// When the context is done, the error wraps ctx.Err() (context.Canceled or
+// context.DeadlineExceeded) and the gas used up to that point is returned.
+
+// InstantiateWithContext works like Instantiate but aborts the contract when ctx is done
+func (vm *VM) InstantiateWithContext(
+	ctx context.Context,
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	initMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.Response, uint64, error) {
+	call := &contextCall{ctx: ctx}
+	store, goapi, querier = call.wrap(store, goapi, querier)
+	res, gasUsed, err := vm.Instantiate(checksum, env, info, initMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return res, gasUsed, call.error(err)
+}
+
+// ExecuteWithContext works like Execute but aborts the contract when ctx is done
+func (vm *VM) ExecuteWithContext(
+	ctx context.Context,
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.Response, uint64, error) {
+	call := &contextCall{ctx: ctx}
+	store, goapi, querier = call.wrap(store, goapi, querier)
+	res, gasUsed, err := vm.Execute(checksum, env, info, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return res, gasUsed, call.error(err)
+}
+
+// QueryWithContext works like Query but aborts the contract when ctx is done
+func (vm *VM) QueryWithContext(
+	ctx context.Context,
+	checksum Checksum,
+	env types.Env,
+	queryMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) ([]byte, uint64, error) {
+	call := &contextCall{ctx: ctx}
+	store, goapi, querier = call.wrap(store, goapi, querier)
+	res, gasUsed, err := vm.Query(checksum, env, queryMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return res, gasUsed, call.error(err)
+}
+
+// contextCall tracks if a contract call was aborted because its context is done
+type contextCall struct {
+	ctx     context.Context
+	aborted atomic.Bool
+}
+
+// error replaces the error of a contract call that was aborted because the context is done.
+// Errors of calls that failed for other reasons are kept, even if the context is done by now.
+func (c *contextCall) error(err error) error {
+	if err != nil && c.aborted.Load() {
+		return fmt.Errorf("contract call aborted: %w", c.ctx.Err())
+	}
+	return err
+}
+
+// check aborts the contract if the context is done. It must only be called from host callbacks.
+func (c *contextCall) check() {
+	if err := c.ctx.Err(); err != nil {
+		c.aborted.Store(true)
+		panic(api.ErrorContextDone{Err: err})
+	}
+}
+
+// wrap returns versions of the given store, API and querier that abort the contract when the context is done
+func (c *contextCall) wrap(store KVStore, goapi GoAPI, querier Querier) (KVStore, GoAPI, Querier) {
+	contextAPI := GoAPI{
+		HumanAddress: func(canon []byte) (string, uint64, error) {
+			c.check()
+			return goapi.HumanAddress(canon)
+		},
+		CanonicalAddress: func(human string) ([]byte, uint64, error) {
+			c.check()
+			return goapi.CanonicalAddress(human)
+		},
+		AddressPreCheck: goapi.AddressPreCheck,
+	}
+	return contextStore{store, c}, contextAPI, contextQuerier{querier, c}
+}
+
+type contextStore struct {
+	KVStore
+	call *contextCall
+}
+
+func (s contextStore) Get(key []byte) []byte {
+	s.call.check()
+	return s.KVStore.Get(key)
+}
+
+func (s contextStore) Set(key, value []byte) {
+	s.call.check()
+	s.KVStore.Set(key, value)
+}
+
+func (s contextStore) Delete(key []byte) {
+	s.call.check()
+	s.KVStore.Delete(key)
+}
+
+func (s contextStore) Iterator(start, end []byte) dbm.Iterator {
+	s.call.check()
+	return s.KVStore.Iterator(start, end)
+}
+
+func (s contextStore) ReverseIterator(start, end []byte) dbm.Iterator {
+	s.call.check()
+	return s.KVStore.ReverseIterator(start, end)
+}
+
+type contextQuerier struct {
+	types.Querier
+	call *contextCall
+}
+
+func (q contextQuerier) Query(request types.QueryRequest, gasLimit uint64) ([]byte, error) {
+	q.call.check()
+	return q.Querier.Query(request, gasLimit)
+}
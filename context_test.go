@@ -0,0 +1,55 @@
+package cosmwasm
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+func TestExecuteWithContext(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(math.MaxUint64)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.InstantiateWithContext(context.Background(), checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	// cancelled before the first storage access
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	info = api.MockInfo("fred", nil)
+	_, gasUsed, err := vm.ExecuteWithContext(ctx, checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotZero(t, gasUsed)
+
+	// errors of calls that fail before calling into the host are kept
+	_, _, err = vm.ExecuteWithContext(ctx, checksum, env, info, []byte(`{"unknown":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, context.Canceled)
+
+	// a deadline stops a contract that would otherwise run for a very long time
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, gasUsed, err = vm.ExecuteWithContext(ctx, checksum, env, info, []byte(`{"storage_loop":{}}`), store, *goapi, querier, gasMeter, math.MaxUint64/2, deserCost)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NotZero(t, gasUsed)
+
+	// queries
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, _, err = vm.QueryWithContext(ctx, checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorIs(t, err, context.Canceled)
+}
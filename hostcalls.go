@@ -0,0 +1,103 @@
+package cosmwasm
+
+import (
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Finschia/wasmvm/types"
+)
+
+// Names of the host calls counted in ExecuteOutput.HostCallCounts
+const (
+	HostCallGet          = "get"
+	HostCallSet          = "set"
+	HostCallDelete       = "delete"
+	HostCallScan         = "scan"
+	HostCallQuery        = "query"
+	HostCallHumanize     = "humanize_address"
+	HostCallCanonicalize = "canonicalize_address"
+)
+
+// hostCallCounter counts the calls from a contract into the host during one contract call
+type hostCallCounter struct {
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+func newHostCallCounter() *hostCallCounter {
+	return &hostCallCounter{counts: make(map[string]uint64)}
+}
+
+func (c *hostCallCounter) inc(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[name]++
+}
+
+// Counts returns a copy of the current counts
+func (c *hostCallCounter) Counts() map[string]uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	out := make(map[string]uint64, len(c.counts))
+	for name, count := range c.counts {
+		out[name] = count
+	}
+	return out
+}
+
+// wrap returns versions of the given store, API and querier that count their calls
+func (c *hostCallCounter) wrap(store KVStore, goapi GoAPI, querier Querier) (KVStore, GoAPI, Querier) {
+	countingAPI := GoAPI{
+		HumanAddress: func(canon []byte) (string, uint64, error) {
+			c.inc(HostCallHumanize)
+			return goapi.HumanAddress(canon)
+		},
+		CanonicalAddress: func(human string) ([]byte, uint64, error) {
+			c.inc(HostCallCanonicalize)
+			return goapi.CanonicalAddress(human)
+		},
+		AddressPreCheck: goapi.AddressPreCheck,
+	}
+	return countingStore{store, c}, countingAPI, countingQuerier{querier, c}
+}
+
+type countingStore struct {
+	KVStore
+	counter *hostCallCounter
+}
+
+func (s countingStore) Get(key []byte) []byte {
+	s.counter.inc(HostCallGet)
+	return s.KVStore.Get(key)
+}
+
+func (s countingStore) Set(key, value []byte) {
+	s.counter.inc(HostCallSet)
+	s.KVStore.Set(key, value)
+}
+
+func (s countingStore) Delete(key []byte) {
+	s.counter.inc(HostCallDelete)
+	s.KVStore.Delete(key)
+}
+
+func (s countingStore) Iterator(start, end []byte) dbm.Iterator {
+	s.counter.inc(HostCallScan)
+	return s.KVStore.Iterator(start, end)
+}
+
+func (s countingStore) ReverseIterator(start, end []byte) dbm.Iterator {
+	s.counter.inc(HostCallScan)
+	return s.KVStore.ReverseIterator(start, end)
+}
+
+type countingQuerier struct {
+	types.Querier
+	counter *hostCallCounter
+}
+
+func (q countingQuerier) Query(request types.QueryRequest, gasLimit uint64) ([]byte, error) {
+	q.counter.inc(HostCallQuery)
+	return q.Querier.Query(request, gasLimit)
+}
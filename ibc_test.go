@@ -2,6 +2,7 @@ package cosmwasm
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"testing"
 
@@ -285,6 +286,10 @@ func TestAnalyzeCode(t *testing.T) {
 	require.False(t, report.HasIBCEntryPoints)
 	require.Equal(t, "", report.RequiredFeatures)
 	require.Equal(t, "", report.RequiredCapabilities)
+	// the cpu_loop execute message
+	require.True(t, report.HasUncheckedLoops)
+	require.Equal(t, []string{"execute", "instantiate", "migrate", "query", "sudo"}, report.EntryPoints)
+	require.Empty(t, report.Warnings)
 
 	// Store IBC contract
 	wasm2, err := ioutil.ReadFile(IBC_TEST_CONTRACT)
@@ -297,6 +302,20 @@ func TestAnalyzeCode(t *testing.T) {
 	require.True(t, report2.HasIBCEntryPoints)
 	require.Equal(t, "iterator,stargate", report2.RequiredFeatures)
 	require.Equal(t, "iterator,stargate", report2.RequiredCapabilities)
+	require.Contains(t, report2.EntryPoints, "ibc_packet_receive")
+
+	// empty and unknown checksums
+	_, err = vm.AnalyzeCode(nil)
+	require.Error(t, err)
+	_, err = vm.AnalyzeCode(make([]byte, 32))
+	require.Error(t, err)
+
+	// the report is JSON serializable
+	bz, err := json.Marshal(report2)
+	require.NoError(t, err)
+	var loaded types.AnalysisReport
+	require.NoError(t, json.Unmarshal(bz, &loaded))
+	require.Equal(t, *report2, loaded)
 }
 
 func TestIBCMsgGetChannel(t *testing.T) {
@@ -339,3 +358,218 @@ func TestIBCMsgGetCounterVersion(t *testing.T) {
 	v, ok = msg4.GetCounterVersion()
 	require.False(t, ok)
 }
+
+func TestReplyFailed(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, IBC_TEST_CONTRACT)
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	initMsg := IBCInstantiateMsg{
+		ReflectCodeID: 77,
+	}
+	_, _, err := vm.Instantiate(checksum, env, info, toBytes(t, initMsg), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	// ibc_reflect only accepts replies for its own submessage ids
+	reply := types.Reply{
+		ID: 12345,
+		Result: types.SubMsgResult{
+			Ok: &types.SubMsgResponse{Events: types.Events{}},
+		},
+	}
+	_, _, err = vm.Reply(checksum, env, reply, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	var replyErr types.ErrReplyFailed
+	require.ErrorAs(t, err, &replyErr)
+	require.Equal(t, uint64(12345), replyErr.SubMsgID)
+	require.NotEmpty(t, replyErr.Msg)
+}
+
+func TestReplyErrCode(t *testing.T) {
+	config := types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+	}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+	code := uint32(5)
+	reply := types.Reply{ID: 12345, Result: types.SubMsgResult{Err: "insufficient funds", ErrCode: &code}}
+
+	// rejected by default
+	vm := withVMConfig(t, config)
+	checksum := createTestContract(t, vm, IBC_TEST_CONTRACT)
+	_, gasUsed, err := vm.Reply(checksum, env, reply, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorIs(t, err, types.ErrReplyErrCodeDisabled)
+	require.Zero(t, gasUsed)
+
+	// passed to the contract if enabled, which fails to parse it with cosmwasm-std 1.x
+	config.ReplyErrorCodes = true
+	vm = withVMConfig(t, config)
+	checksum = createTestContract(t, vm, IBC_TEST_CONTRACT)
+	_, _, err = vm.Reply(checksum, env, reply, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorContains(t, err, "Error parsing into type")
+}
+
+func TestReplyFromBuildReply(t *testing.T) {
+	const CHANNEL_ID = "channel-432"
+
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, IBC_TEST_CONTRACT)
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	initMsg := IBCInstantiateMsg{
+		ReflectCodeID: 101,
+	}
+	_, _, err := vm.Instantiate(checksum, env, info, toBytes(t, initMsg), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	openMsg := api.MockIBCChannelOpenInit(CHANNEL_ID, types.Ordered, IBC_VERSION)
+	_, _, err = vm.IBCChannelOpen(checksum, env, openMsg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	connectMsg := api.MockIBCChannelConnectAck(CHANNEL_ID, types.Ordered, IBC_VERSION)
+	res, _, err := vm.IBCChannelConnect(checksum, env, connectMsg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Messages))
+	id := res.Messages[0].ID
+
+	// error path
+	reply := types.BuildReply(id, nil, nil, errors.New("instantiation failed"))
+	_, _, err = vm.Reply(checksum, env, reply, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	var replyErr types.ErrReplyFailed
+	require.ErrorAs(t, err, &replyErr)
+	require.Equal(t, id, replyErr.SubMsgID)
+
+	// success path
+	events := types.Events{{
+		Type:       "instantiate",
+		Attributes: types.EventAttributes{{Key: "_contract_address", Value: "reflect-acct-2"}},
+	}}
+	reply = types.BuildReply(id, events, nil, nil)
+	_, _, err = vm.Reply(checksum, env, reply, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	queryMsg := IBCQueryMsg{
+		ListAccounts: &struct{}{},
+	}
+	qres, _, err := vm.Query(checksum, env, toBytes(t, queryMsg), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	var accounts ListAccountsResponse
+	err = json.Unmarshal(qres, &accounts)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(accounts.Accounts))
+	require.Equal(t, "reflect-acct-2", accounts.Accounts[0].Account)
+}
+
+func TestIBCPacketReceiveResponseLimits(t *testing.T) {
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures:        TESTING_FEATURES,
+		MemoryLimit:              TESTING_MEMORY_LIMIT,
+		CacheSize:                TESTING_CACHE_SIZE,
+		MaxEventsPerResponse:     1,
+		MaxAttributesPerResponse: 1,
+	})
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+
+	// ibc_reflect answers packets on unknown channels with one event of one attribute
+	checksum := createTestContract(t, vm, IBC_TEST_CONTRACT)
+	initMsg := IBCInstantiateMsg{ReflectCodeID: 77}
+	_, _, err := vm.Instantiate(checksum, env, api.MockInfo("creator", nil), toBytes(t, initMsg), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	msg := api.MockIBCPacketReceive("no-such-channel", []byte(`{"who_am_i":{}}`))
+	res, _, err := vm.IBCPacketReceive(checksum, env, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Len(t, res.Ok.Events, 1)
+
+	// limits are 1 at least, so exceeding them needs a crafted response
+	receive := func(result string) error {
+		checksum, err := vm.Create(api.MinimalIBCContract(result))
+		require.NoError(t, err)
+		_, _, err = vm.IBCPacketReceive(checksum, env, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+		return err
+	}
+	err = receive(`{"ok":{"acknowledgement":"","messages":[],"attributes":[],"events":[{"type":"a","attributes":[]},{"type":"b","attributes":[]}]}}`)
+	require.ErrorIs(t, err, types.ErrTooManyEvents)
+	err = receive(`{"ok":{"acknowledgement":"","messages":[],"attributes":[{"key":"a","value":"b"}],"events":[{"type":"a","attributes":[{"key":"c","value":"d"}]}]}}`)
+	require.ErrorIs(t, err, types.ErrTooManyAttributes)
+}
+
+func TestIBCPacketReceiveResponsePolicies(t *testing.T) {
+	const bankMsg = `{"bank":{"send":{"to_address":"friend","amount":[]}}}`
+	cases := map[string]struct {
+		cfg     types.VMConfig
+		result  string
+		expErr  error
+		expResp *types.IBCReceiveResponse
+	}{
+		"duplicate event types": {
+			cfg:    types.VMConfig{UniqueEventTypes: true},
+			result: `{"ok":{"acknowledgement":"","messages":[],"attributes":[],"events":[{"type":"a","attributes":[]},{"type":"a","attributes":[]}]}}`,
+			expErr: types.ErrDuplicateEventType,
+		},
+		"reply without reply entry point": {
+			result: `{"ok":{"acknowledgement":"","messages":[{"id":1,"msg":` + bankMsg + `,"reply_on":"always"}],"attributes":[],"events":[]}}`,
+			expErr: types.ErrNoReplyHandler,
+		},
+		"suspicious submessage": {
+			cfg:    types.VMConfig{StrictSubMessages: true},
+			result: `{"ok":{"acknowledgement":"","messages":[{"id":1,"msg":` + bankMsg + `,"gas_limit":5000,"reply_on":"never"}],"attributes":[],"events":[]}}`,
+			expErr: types.ErrSuspiciousSubMsg,
+		},
+		"canonical events": {
+			cfg:    types.VMConfig{CanonicalEvents: true},
+			result: `{"ok":{"acknowledgement":"","messages":[],"attributes":[{"key":"b","value":"1"},{"key":"a","value":"1"}],"events":[{"type":"foo","attributes":[]},{"type":"bar","attributes":[]}]}}`,
+			expResp: &types.IBCReceiveResponse{
+				Acknowledgement: []byte{},
+				Messages:        []types.SubMsg{},
+				Attributes:      []types.EventAttribute{{Key: "a", Value: "1"}, {Key: "b", Value: "1"}},
+				Events:          []types.Event{{Type: "bar"}, {Type: "foo"}},
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.SupportedFeatures = TESTING_FEATURES
+			cfg.MemoryLimit = TESTING_MEMORY_LIMIT
+			cfg.CacheSize = TESTING_CACHE_SIZE
+			vm := withVMConfig(t, cfg)
+			checksum, err := vm.Create(api.MinimalIBCContract(tc.result))
+			require.NoError(t, err)
+
+			deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+			gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+			store := api.NewLookup(gasMeter)
+			goapi := api.NewMockAPI()
+			querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+			msg := api.MockIBCPacketReceive("channel-1", []byte(`{}`))
+			res, _, err := vm.IBCPacketReceive(checksum, api.MockEnv(), msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+			if tc.expErr != nil {
+				require.ErrorIs(t, err, tc.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expResp, res.Ok)
+		})
+	}
+}
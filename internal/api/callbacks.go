@@ -39,6 +39,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"reflect"
 	"runtime/debug"
 	"unsafe"
@@ -51,7 +52,7 @@ import (
 // Note: we have to include all exports in the same file (at least since they both import bindings.h),
 // or get odd cgo build errors about duplicate definitions
 
-func recoverPanic(ret *C.GoError) {
+func recoverPanic(ret *C.GoError, errOut *C.UnmanagedVector) {
 	if rec := recover(); rec != nil {
 		// This is used to handle ErrorOutOfGas panics.
 		//
@@ -84,6 +85,15 @@ func recoverPanic(ret *C.GoError) {
 		case "ErrorOutOfGas":
 			// TODO: figure out how to pass the text in its `Descriptor` field through all the FFI
 			*ret = C.GoError_OutOfGas
+		case "ErrorContextDone":
+			// An expected way to abort the contract, see ErrorContextDone
+			*ret = C.GoError_Panic
+		case "ErrorReadOnlyStore":
+			// A contract bug rather than a host bug, so the message is passed to the caller instead of logged
+			if errOut != nil && (*errOut).is_none {
+				*errOut = newUnmanagedVector([]byte(rec.(ErrorReadOnlyStore).Error()))
+			}
+			*ret = C.GoError_User
 		default:
 			log.Printf("Panic in Go callback: %#v\n", rec)
 			debug.PrintStack()
@@ -92,6 +102,12 @@ func recoverPanic(ret *C.GoError) {
 	}
 }
 
+// ErrorContextDone is a panic value used to abort a contract from within a callback when the
+// context of the call is done. It is handled like a panic without logging it.
+type ErrorContextDone struct {
+	Err error
+}
+
 type Gas = uint64
 
 // GasMeter is a copy of an interface declaration from finschia-sdk
@@ -100,6 +116,42 @@ type GasMeter interface {
 	GasConsumed() Gas
 }
 
+// gasMeterWithLimit is a GasMeter that knows its limit, like the finschia-sdk gas meters and the mock gas meters
+type gasMeterWithLimit interface {
+	GasMeter
+	Limit() Gas
+}
+
+// gasMeterWithRemaining is a GasMeter that reports the gas left itself, like the gas meters of newer SDK versions
+type gasMeterWithRemaining interface {
+	GasMeter
+	GasRemaining() Gas
+}
+
+// RemainingGas returns the gas left in gasMeter, i.e. its limit minus the gas consumed, or 0 if the limit
+// is exceeded. This can be used by a Querier to budget sub-queries.
+//
+// GasMeters without a Limit method and those with a limit of 0, like the infinite gas meter of
+// finschia-sdk, are considered unlimited, for which math.MaxUint64 is returned. If the gas meter
+// has a GasRemaining method, its result is used as is.
+//
+// The gas used by a contract itself is only charged to the gas meter after the contract call, so during
+// a call this is an upper bound of the gas available.
+func RemainingGas(gasMeter GasMeter) uint64 {
+	if meter, ok := gasMeter.(gasMeterWithRemaining); ok {
+		return meter.GasRemaining()
+	}
+	meter, ok := gasMeter.(gasMeterWithLimit)
+	if !ok || meter.Limit() == 0 {
+		return math.MaxUint64
+	}
+	limit, consumed := meter.Limit(), meter.GasConsumed()
+	if consumed >= limit {
+		return 0
+	}
+	return limit - consumed
+}
+
 /****** DB ********/
 
 // KVStore copies a subset of types from finschia-sdk
@@ -122,6 +174,35 @@ type KVStore interface {
 	ReverseIterator(start, end []byte) dbm.Iterator
 }
 
+// ErrorReadOnlyStore is a panic value used when a store wrapped with ReadOnlyStore is written to.
+// The callbacks turn it into an error of the contract call containing its message.
+type ErrorReadOnlyStore struct {
+	Op string
+}
+
+func (e ErrorReadOnlyStore) Error() string {
+	return fmt.Sprintf("%s called on read-only store", e.Op)
+}
+
+// readOnlyStore is a KVStore that panics on writes
+type readOnlyStore struct {
+	KVStore
+}
+
+// ReadOnlyStore wraps store such that reads are passed through and writes panic with ErrorReadOnlyStore.
+// This is used for queries, which must not change the state.
+func ReadOnlyStore(store KVStore) KVStore {
+	return readOnlyStore{store}
+}
+
+func (s readOnlyStore) Set(key, value []byte) {
+	panic(ErrorReadOnlyStore{Op: "Set"})
+}
+
+func (s readOnlyStore) Delete(key []byte) {
+	panic(ErrorReadOnlyStore{Op: "Delete"})
+}
+
 var db_vtable = C.Db_vtable{
 	read_db:   (C.read_db_fn)(C.cGet_cgo),
 	write_db:  (C.write_db_fn)(C.cSet_cgo),
@@ -133,20 +214,32 @@ type DBState struct {
 	Store KVStore
 	// CallID is used to lookup the proper frame for iterators associated with this contract call (iterator.go)
 	CallID uint64
+	// IteratorGasCosts are the fixed gas costs of the iterator callbacks for this contract call
+	IteratorGasCosts types.IteratorGasCosts
+	// UnmeteredGas is the fixed iterator gas that could not be consumed in the gas meter because it is read-only.
+	// It is added to the gas reported for this contract call (see reportedGas).
+	UnmeteredGas Gas
 }
 
 // use this to create C.Db in two steps, so the pointer lives as long as the calling stack
 
-// state := buildDBState(kv, callID)
+// state := buildDBState(kv, callID, costs)
 // db := buildDB(&state, &gasMeter)
 // // then pass db into some FFI function
-func buildDBState(kv KVStore, callID uint64) DBState {
+func buildDBState(kv KVStore, callID uint64, iteratorGasCosts types.IteratorGasCosts) DBState {
 	return DBState{
-		Store:  kv,
-		CallID: callID,
+		Store:            kv,
+		CallID:           callID,
+		IteratorGasCosts: iteratorGasCosts,
 	}
 }
 
+// reportedGas returns the gas reported for a contract call, which is the gas used by the VM
+// plus the fixed iterator gas that was not consumed in the gas meter.
+func (state *DBState) reportedGas(gasUsed cu64) uint64 {
+	return uint64(gasUsed) + state.UnmeteredGas
+}
+
 // contract: original pointer/struct referenced must live longer than C.Db struct
 // since this is only used internally, we can verify the code that this is the case
 func buildDB(state *DBState, gm *GasMeter) C.Db {
@@ -157,6 +250,35 @@ func buildDB(state *DBState, gm *GasMeter) C.Db {
 	}
 }
 
+// pricedIterator is an iterator created by cScan, which carries the gas charged by cNext
+// and the state of its contract call to record unmetered gas in
+type pricedIterator struct {
+	dbm.Iterator
+	nextGas Gas
+	state   *DBState
+}
+
+// gasConsumer is implemented by writable gas meters like the one from finschia-sdk
+type gasConsumer interface {
+	ConsumeGas(amount Gas, descriptor string)
+}
+
+// chargeFixedGas charges a fixed amount of gas in a callback. If the gas meter is writable, the gas is
+// consumed there (and thus included in the gas meter difference the callbacks report). Otherwise the
+// amount is recorded as unmetered gas of the contract call, so that it is included in the reported
+// gas, and returned to be added to the gas the callback reports.
+func chargeFixedGas(gm GasMeter, state *DBState, amount Gas, descriptor string) Gas {
+	if amount == 0 {
+		return 0
+	}
+	if consumer, ok := gm.(gasConsumer); ok {
+		consumer.ConsumeGas(amount, descriptor)
+		return 0
+	}
+	state.UnmeteredGas += amount
+	return amount
+}
+
 var iterator_vtable = C.Iterator_vtable{
 	next_db: (C.next_db_fn)(C.cNext_cgo),
 }
@@ -181,7 +303,7 @@ func buildIterator(callID uint64, it dbm.Iterator) (C.iterator_t, error) {
 
 //export cGet
 func cGet(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *cu64, key C.U8SliceView, val *C.UnmanagedVector, errOut *C.UnmanagedVector) (ret C.GoError) {
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 
 	if ptr == nil || gasMeter == nil || usedGas == nil || val == nil || errOut == nil {
 		// we received an invalid pointer
@@ -209,7 +331,7 @@ func cGet(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *cu64, key C.U8SliceView
 
 //export cSet
 func cSet(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, key C.U8SliceView, val C.U8SliceView, errOut *C.UnmanagedVector) (ret C.GoError) {
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 
 	if ptr == nil || gasMeter == nil || usedGas == nil || errOut == nil {
 		// we received an invalid pointer
@@ -234,7 +356,7 @@ func cSet(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, key C.U8Sli
 
 //export cDelete
 func cDelete(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, key C.U8SliceView, errOut *C.UnmanagedVector) (ret C.GoError) {
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 
 	if ptr == nil || gasMeter == nil || usedGas == nil || errOut == nil {
 		// we received an invalid pointer
@@ -258,7 +380,7 @@ func cDelete(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, key C.U8
 
 //export cScan
 func cScan(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, start C.U8SliceView, end C.U8SliceView, order ci32, out *C.GoIter, errOut *C.UnmanagedVector) (ret C.GoError) {
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 
 	if ptr == nil || gasMeter == nil || usedGas == nil || out == nil || errOut == nil {
 		// we received an invalid pointer
@@ -284,10 +406,11 @@ func cScan(ptr *C.db_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, start C.U8
 	default:
 		return C.GoError_BadArgument
 	}
+	extraGas := chargeFixedGas(gm, state, state.IteratorGasCosts.Scan, "wasm iterator scan")
 	gasAfter := gm.GasConsumed()
-	*usedGas = (C.uint64_t)(gasAfter - gasBefore)
+	*usedGas = (C.uint64_t)(gasAfter - gasBefore + extraGas)
 
-	cIterator, err := buildIterator(state.CallID, iter)
+	cIterator, err := buildIterator(state.CallID, pricedIterator{Iterator: iter, nextGas: state.IteratorGasCosts.Next, state: state})
 	if err != nil {
 		// store the actual error message in the return buffer
 		*errOut = newUnmanagedVector([]byte(err.Error()))
@@ -307,7 +430,7 @@ func cNext(ref C.iterator_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, key *
 	// 		...
 	// 	}
 
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 	if ref.call_id == 0 || gasMeter == nil || usedGas == nil || key == nil || val == nil || errOut == nil {
 		// we received an invalid pointer
 		return C.GoError_BadArgument
@@ -321,19 +444,25 @@ func cNext(ref C.iterator_t, gasMeter *C.gas_meter_t, usedGas *C.uint64_t, key *
 	if iter == nil {
 		panic("Unable to retrieve iterator.")
 	}
+	var extraGas Gas
+	gasBefore := gm.GasConsumed()
+	if priced, ok := iter.(pricedIterator); ok {
+		extraGas = chargeFixedGas(gm, priced.state, priced.nextGas, "wasm iterator next")
+	}
 	if !iter.Valid() {
 		// end of iterator, return as no-op, nil key is considered end
+		gasAfter := gm.GasConsumed()
+		*usedGas = (C.uint64_t)(gasAfter - gasBefore + extraGas)
 		return C.GoError_None
 	}
 
-	gasBefore := gm.GasConsumed()
 	// call Next at the end, upon creation we have first data loaded
 	k := iter.Key()
 	v := iter.Value()
 	// check iter.Error() ????
 	iter.Next()
 	gasAfter := gm.GasConsumed()
-	*usedGas = (C.uint64_t)(gasAfter - gasBefore)
+	*usedGas = (C.uint64_t)(gasAfter - gasBefore + extraGas)
 
 	*key = newUnmanagedVector(k)
 	*val = newUnmanagedVector(v)
@@ -350,6 +479,11 @@ type (
 type GoAPI struct {
 	HumanAddress     HumanizeAddress
 	CanonicalAddress CanonicalizeAddress
+	// AddressPreCheck is optional. If set, it is called with every human address before CanonicalAddress.
+	// An error rejects the address without calling CanonicalAddress and without charging gas.
+	// This is an additional check: addresses it accepts must still be accepted by CanonicalAddress,
+	// so it can restrict the addresses of a chain but not extend them.
+	AddressPreCheck func(human string) error
 }
 
 var api_vtable = C.GoApi_vtable{
@@ -368,7 +502,7 @@ func buildAPI(api *GoAPI) C.GoApi {
 
 //export cHumanAddress
 func cHumanAddress(ptr *C.api_t, src C.U8SliceView, dest *C.UnmanagedVector, errOut *C.UnmanagedVector, used_gas *cu64) (ret C.GoError) {
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 
 	if dest == nil || errOut == nil {
 		return C.GoError_BadArgument
@@ -396,7 +530,7 @@ func cHumanAddress(ptr *C.api_t, src C.U8SliceView, dest *C.UnmanagedVector, err
 
 //export cCanonicalAddress
 func cCanonicalAddress(ptr *C.api_t, src C.U8SliceView, dest *C.UnmanagedVector, errOut *C.UnmanagedVector, used_gas *cu64) (ret C.GoError) {
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 
 	if dest == nil || errOut == nil {
 		return C.GoError_BadArgument
@@ -407,6 +541,12 @@ func cCanonicalAddress(ptr *C.api_t, src C.U8SliceView, dest *C.UnmanagedVector,
 
 	api := (*GoAPI)(unsafe.Pointer(ptr))
 	s := string(copyU8Slice(src))
+	if api.AddressPreCheck != nil {
+		if err := api.AddressPreCheck(s); err != nil {
+			*errOut = newUnmanagedVector([]byte(err.Error()))
+			return C.GoError_User
+		}
+	}
 	c, cost, err := api.CanonicalAddress(s)
 	*used_gas = cu64(cost)
 	if err != nil {
@@ -438,7 +578,7 @@ func buildQuerier(q *Querier) C.GoQuerier {
 
 //export cQueryExternal
 func cQueryExternal(ptr *C.querier_t, gasLimit C.uint64_t, usedGas *C.uint64_t, request C.U8SliceView, result *C.UnmanagedVector, errOut *C.UnmanagedVector) (ret C.GoError) {
-	defer recoverPanic(&ret)
+	defer recoverPanic(&ret, errOut)
 
 	if ptr == nil || usedGas == nil || result == nil || errOut == nil {
 		// we received an invalid pointer
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureContracts are the testdata contracts stored in the shared fixture cache by name
+var fixtureContracts = map[string]string{
+	"hackatom": "../../testdata/hackatom.wasm",
+	"queue":    "../../testdata/queue.wasm",
+	"reflect":  "../../testdata/reflect.wasm",
+}
+
+var sharedFixtures struct {
+	once      sync.Once
+	dir       string
+	cache     Cache
+	checksums map[string][]byte
+	err       error
+}
+
+// SharedFixtureCache returns a cache that contains the fixture contracts and their checksums by name.
+// The cache is created and the contracts are compiled once per test binary, which saves compiling them
+// in every test. Tests must not change the cache (pin, unpin, store other code or check metrics), so
+// use withCache for those.
+func SharedFixtureCache(t testing.TB) (Cache, map[string][]byte) {
+	sharedFixtures.once.Do(func() {
+		sharedFixtures.dir, sharedFixtures.err = ioutil.TempDir("", "wasmvm-fixtures")
+		if sharedFixtures.err != nil {
+			return
+		}
+		sharedFixtures.cache, sharedFixtures.err = InitCache(sharedFixtures.dir, TESTING_FEATURES, TESTING_CACHE_SIZE, TESTING_MEMORY_LIMIT)
+		if sharedFixtures.err != nil {
+			return
+		}
+		sharedFixtures.checksums = make(map[string][]byte, len(fixtureContracts))
+		for name, path := range fixtureContracts {
+			var wasm []byte
+			wasm, sharedFixtures.err = ioutil.ReadFile(path)
+			if sharedFixtures.err != nil {
+				return
+			}
+			sharedFixtures.checksums[name], sharedFixtures.err = Create(sharedFixtures.cache, wasm)
+			if sharedFixtures.err != nil {
+				return
+			}
+		}
+	})
+	require.NoError(t, sharedFixtures.err)
+
+	// a copy, such that tests cannot change the fixtures of other tests
+	checksums := make(map[string][]byte, len(sharedFixtures.checksums))
+	for name, checksum := range sharedFixtures.checksums {
+		checksums[name] = append([]byte(nil), checksum...)
+	}
+	return sharedFixtures.cache, checksums
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if sharedFixtures.cache.ptr != nil {
+		ReleaseCache(sharedFixtures.cache)
+	}
+	if sharedFixtures.dir != "" {
+		os.RemoveAll(sharedFixtures.dir)
+	}
+	os.Exit(code)
+}
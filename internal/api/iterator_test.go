@@ -166,6 +166,85 @@ func TestRetrieveIterator(t *testing.T) {
 	endCall(callID2)
 }
 
+func TestLookupIteratorOrder(t *testing.T) {
+	store := NewLookup(NewMockGasMeter(TESTING_GAS_LIMIT))
+	for _, key := range []string{"delta", "alpha", "echo", "charlie", "bravo"} {
+		store.Set([]byte(key), []byte("value of "+key))
+	}
+
+	collect := func(iter dbm.Iterator) []string {
+		defer iter.Close()
+		keys := []string{}
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+	require.Equal(t, []string{"alpha", "bravo", "charlie", "delta", "echo"}, collect(store.Iterator(nil, nil)))
+	require.Equal(t, []string{"echo", "delta", "charlie", "bravo", "alpha"}, collect(store.ReverseIterator(nil, nil)))
+	// end is exclusive
+	require.Equal(t, []string{"bravo", "charlie"}, collect(store.Iterator([]byte("bravo"), []byte("delta"))))
+	require.Equal(t, []string{"charlie", "bravo"}, collect(store.ReverseIterator([]byte("bravo"), []byte("delta"))))
+}
+
+func TestLookupIteratorBounds(t *testing.T) {
+	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := NewLookup(gasMeter)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		store.Set([]byte(key), []byte(key))
+	}
+
+	collect := func(iter dbm.Iterator) []string {
+		defer iter.Close()
+		keys := []string{}
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+	// start is inclusive, end is exclusive, also for keys that are not stored
+	require.Equal(t, []string{"b", "c"}, collect(store.Iterator([]byte("b"), []byte("d"))))
+	require.Equal(t, []string{"c", "b"}, collect(store.ReverseIterator([]byte("b"), []byte("d"))))
+	require.Equal(t, []string{"c", "d"}, collect(store.Iterator([]byte("bb"), []byte("e"))))
+	require.Equal(t, []string{"d", "c"}, collect(store.ReverseIterator([]byte("bb"), []byte("e"))))
+	// open bounds
+	require.Equal(t, []string{"a", "b"}, collect(store.Iterator(nil, []byte("c"))))
+	require.Equal(t, []string{"d", "c"}, collect(store.ReverseIterator([]byte("c"), nil)))
+	// empty ranges
+	require.Equal(t, []string{}, collect(store.Iterator([]byte("bb"), []byte("bc"))))
+	require.Equal(t, []string{}, collect(store.ReverseIterator([]byte("e"), nil)))
+	require.Equal(t, []string{}, collect(store.Iterator(nil, []byte("a"))))
+
+	// every iterator is charged once, independent of the number of entries
+	before := gasMeter.GasConsumed()
+	collect(store.Iterator(nil, nil))
+	collect(store.ReverseIterator([]byte("e"), nil))
+	require.Equal(t, uint64(2*RangePrice), gasMeter.GasConsumed()-before)
+}
+
+func TestLookupExportImport(t *testing.T) {
+	store := NewLookup(NewMockGasMeter(TESTING_GAS_LIMIT))
+	store.Set([]byte("foo"), []byte("bar"))
+	store.Set([]byte{0x00, 0xff}, []byte{})
+	store.Set([]byte("long"), make([]byte, 1000))
+
+	data, err := store.Export()
+	require.NoError(t, err)
+
+	restored, err := ImportLookup(data, NewMockGasMeter(TESTING_GAS_LIMIT))
+	require.NoError(t, err)
+	require.Equal(t, []byte("bar"), restored.Get([]byte("foo")))
+	require.Equal(t, []byte{}, restored.Get([]byte{0x00, 0xff}))
+	require.Equal(t, make([]byte, 1000), restored.Get([]byte("long")))
+	data2, err := restored.Export()
+	require.NoError(t, err)
+	require.Equal(t, data, data2)
+
+	// truncated data
+	_, err = ImportLookup(data[:len(data)-1], NewMockGasMeter(TESTING_GAS_LIMIT))
+	require.Error(t, err)
+}
+
 func TestQueueIteratorSimple(t *testing.T) {
 	cache, cleanup := withCache(t)
 	defer cleanup()
@@ -198,6 +277,74 @@ func TestQueueIteratorSimple(t *testing.T) {
 	require.Equal(t, `{"counters":[[17,22],[22,0]]}`, string(reduced.Ok))
 }
 
+func TestQueueIteratorGasCosts(t *testing.T) {
+	cache, cleanup := withCache(t)
+	defer cleanup()
+
+	setup := setupQueueContract(t, cache)
+	checksum, querier, api := setup.checksum, setup.querier, setup.api
+	env := MockEnvBin(t)
+	// one scan and three calls to next for the two elements
+	query := []byte(`{"sum":{}}`)
+
+	sumGas := func() uint64 {
+		gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+		igasMeter := GasMeter(gasMeter)
+		store := setup.Store(gasMeter)
+		_, _, err := Query(cache, checksum, env, query, &igasMeter, store, api, &querier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+		require.NoError(t, err)
+		return gasMeter.GasConsumed()
+	}
+
+	require.Equal(t, types.IteratorGasCosts{}, cache.iteratorGasCosts)
+	defaultGas := sumGas()
+
+	cache.SetIteratorGasCosts(types.IteratorGasCosts{Scan: 1000, Next: 10})
+	require.Equal(t, defaultGas+1000+3*10, sumGas())
+
+	// the costs are per cache
+	other, cleanupOther := withCache(t)
+	defer cleanupOther()
+	require.Equal(t, types.IteratorGasCosts{}, other.iteratorGasCosts)
+}
+
+// readOnlyGasMeter hides the ConsumeGas method of the wrapped gas meter
+type readOnlyGasMeter struct {
+	meter GasMeter
+}
+
+func (g readOnlyGasMeter) GasConsumed() Gas {
+	return g.meter.GasConsumed()
+}
+
+func TestQueueIteratorGasCostsReadOnlyGasMeter(t *testing.T) {
+	cache, cleanup := withCache(t)
+	defer cleanup()
+
+	setup := setupQueueContract(t, cache)
+	checksum, querier, api := setup.checksum, setup.querier, setup.api
+	env := MockEnvBin(t)
+	// one scan and three calls to next for the two elements
+	query := []byte(`{"sum":{}}`)
+
+	sumGas := func() (uint64, uint64) {
+		gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+		igasMeter := GasMeter(readOnlyGasMeter{meter: gasMeter})
+		store := setup.Store(gasMeter)
+		_, gasUsed, err := Query(cache, checksum, env, query, &igasMeter, store, api, &querier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+		require.NoError(t, err)
+		return gasUsed, gasMeter.GasConsumed()
+	}
+
+	defaultReported, defaultMetered := sumGas()
+
+	// the costs cannot be consumed in the gas meter, so they are reported by the VM instead
+	cache.SetIteratorGasCosts(types.IteratorGasCosts{Scan: 1000, Next: 10})
+	reported, metered := sumGas()
+	require.Equal(t, defaultReported+1000+3*10, reported)
+	require.Equal(t, defaultMetered, metered)
+}
+
 func TestQueueIteratorRaces(t *testing.T) {
 	cache, cleanup := withCache(t)
 	defer cleanup()
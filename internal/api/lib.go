@@ -5,8 +5,15 @@ package api
 import "C"
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/Finschia/wasmvm/types"
@@ -30,6 +37,10 @@ type cu8_ptr = *C.uint8_t
 
 type Cache struct {
 	ptr *C.cache_t
+	// dataDir is the base directory of the cache
+	dataDir string
+	// iteratorGasCosts are charged by the iterator callbacks of all contract calls using this cache
+	iteratorGasCosts types.IteratorGasCosts
 }
 
 type Querier = types.Querier
@@ -49,7 +60,45 @@ func InitCache(dataDir string, supportedFeatures string, cacheSize uint32, insta
 	if err != nil {
 		return Cache{}, errorWithMessage(err, errmsg)
 	}
-	return Cache{ptr: ptr}, nil
+	cache := Cache{ptr: ptr, dataDir: dataDir}
+	if _, err := os.Stat(filepath.Join(dataDir, CacheVersionFile)); os.IsNotExist(err) {
+		// A cache that already contains modules was created by a version that did not write the
+		// version file, so it must not be marked as created by the current version.
+		populated, err := hasCompiledModules(dataDir)
+		if err == nil && !populated {
+			err = WriteCacheVersion(dataDir)
+		}
+		if err != nil {
+			ReleaseCache(cache)
+			return Cache{}, err
+		}
+	}
+	return cache, nil
+}
+
+// CacheVersionFile is the name of the file in the data directory that contains the libwasmvm version
+// which created the cache. It is written by InitCache for new caches.
+const CacheVersionFile = "libwasmvm_version"
+
+// hasCompiledModules returns true if the file system cache of compiled modules is not empty
+func hasCompiledModules(dataDir string) (bool, error) {
+	modules, err := filepath.Glob(filepath.Join(dataDir, "cache", "modules", "*", "*"))
+	return len(modules) != 0, err
+}
+
+// WriteCacheVersion writes the version of the libwasmvm in use to the version file of the cache
+func WriteCacheVersion(dataDir string) error {
+	version, err := LibwasmvmVersion()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dataDir, CacheVersionFile), []byte(version), 0o644)
+}
+
+// SetIteratorGasCosts sets the fixed gas costs of the iterator callbacks for all subsequent contract
+// calls using this cache
+func (cache *Cache) SetIteratorGasCosts(costs types.IteratorGasCosts) {
+	cache.iteratorGasCosts = costs
 }
 
 func ReleaseCache(cache Cache) {
@@ -67,6 +116,45 @@ func Create(cache Cache, wasm []byte) ([]byte, error) {
 	return copyAndDestroyUnmanagedVector(checksum), nil
 }
 
+// StoreCodeUnchecked stores the code in the cache without any validation and returns its checksum.
+// Like save_wasm_unchecked in cosmwasm-vm, which libwasmvm does not expose, the code is written
+// to the code directory of the cache (see wasmDir). It is compiled when it is used for the first time.
+//
+// The code is written to a temporary file which is then renamed, such that libwasmvm never reads
+// partially written code, even if the process is interrupted.
+func StoreCodeUnchecked(cache Cache, wasm []byte) ([]byte, error) {
+	hash := sha256.Sum256(wasm)
+	path, err := codePath(cache, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	// the temporary file must be in the same directory for the rename to be atomic
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(wasm); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	// CreateTemp uses 0600
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return hash[:], nil
+}
+
 func GetCode(cache Cache, checksum []byte) ([]byte, error) {
 	cs := makeView(checksum)
 	defer runtime.KeepAlive(checksum)
@@ -78,6 +166,162 @@ func GetCode(cache Cache, checksum []byte) ([]byte, error) {
 	return copyAndDestroyUnmanagedVector(wasm), nil
 }
 
+// GetCodeTo copies the Wasm code for checksum to w in chunks of chunkSize bytes and returns the
+// number of bytes written. It returns types.ErrCodeNotFound if no code is stored for checksum.
+//
+// libwasmvm does not provide streaming, so the code file is read from the cache directory (see wasmDir).
+// The code is hashed while it is copied. If it does not match the checksum, an error wrapping
+// types.ErrChecksumMismatch is returned after the code was written, and w must discard it.
+func GetCodeTo(cache Cache, checksum []byte, w io.Writer, chunkSize int) (int64, error) {
+	path, err := codePath(cache, checksum)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("%w: %X", types.ErrCodeNotFound, checksum)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	written, err := copyChunks(io.MultiWriter(w, hasher), file, make([]byte, chunkSize))
+	if err != nil {
+		return written, err
+	}
+	if !bytes.Equal(hasher.Sum(nil), checksum) {
+		return written, fmt.Errorf("%w: stored code does not match %X", types.ErrChecksumMismatch, checksum)
+	}
+	return written, nil
+}
+
+// copyChunks copies src to dst using buf, such that no write is larger than buf.
+// io.CopyBuffer is not used since it ignores the buffer when src implements io.WriterTo.
+func copyChunks(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	var written int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			m, werr := dst.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// GetCodes returns the checksums of all codes stored in the cache, sorted in ascending order.
+// libwasmvm does not provide this, so the code directory of the cache is read (see wasmDir).
+func GetCodes(cache Cache) ([][]byte, error) {
+	dir, err := wasmDir(cache)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return [][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// ReadDir returns the entries sorted by file name, i.e. by hex encoded checksum
+	checksums := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		checksum, err := hex.DecodeString(entry.Name())
+		if err != nil || len(checksum) != 32 || entry.IsDir() {
+			// not a code file
+			continue
+		}
+		checksums = append(checksums, checksum)
+	}
+	return checksums, nil
+}
+
+// CheckCacheVersion returns types.ErrCacheVersionMismatch if the cache in dataDir was not created by
+// the libwasmvm version in use, according to its version file. Caches without version file are
+// reported with types.UnknownCacheVersion.
+func CheckCacheVersion(dataDir string) error {
+	version, err := LibwasmvmVersion()
+	if err != nil {
+		return err
+	}
+	cacheVersion, err := os.ReadFile(filepath.Join(dataDir, CacheVersionFile))
+	if os.IsNotExist(err) {
+		return types.ErrCacheVersionMismatch{CacheVersion: types.UnknownCacheVersion, LibraryVersion: version}
+	}
+	if err != nil {
+		return err
+	}
+	if string(cacheVersion) != version {
+		return types.ErrCacheVersionMismatch{CacheVersion: string(cacheVersion), LibraryVersion: version}
+	}
+	return nil
+}
+
+// wasmDir returns the directory in which libwasmvm stores the Wasm code of the cache, one file per
+// hex encoded checksum. This layout is private to cosmwasm-vm and only known for the libwasmvm
+// version in use, so types.ErrCacheVersionMismatch is returned for caches created by other versions.
+// All functions that access the code files directly must use this.
+func wasmDir(cache Cache) (string, error) {
+	if err := CheckCacheVersion(cache.dataDir); err != nil {
+		return "", err
+	}
+	return filepath.Join(cache.dataDir, "state", "wasm"), nil
+}
+
+// codePath returns the path of the Wasm code for checksum in the cache directory (see wasmDir)
+func codePath(cache Cache, checksum []byte) (string, error) {
+	if len(checksum) != 32 {
+		return "", fmt.Errorf("invalid checksum length %d, expected 32", len(checksum))
+	}
+	dir, err := wasmDir(cache)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, hex.EncodeToString(checksum)), nil
+}
+
+// RemoveCode deletes the Wasm code and the compiled modules for checksum from the cache directory.
+// It returns types.ErrCodeNotFound if no code is stored for checksum.
+//
+// libwasmvm does not provide this, so the files are removed directly. A module that is already
+// loaded in the in-memory cache stays there and remains executable until it is evicted or the
+// cache is released.
+func RemoveCode(cache Cache, checksum []byte) error {
+	path, err := codePath(cache, checksum)
+	if err != nil {
+		return err
+	}
+	name := hex.EncodeToString(checksum)
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %X", types.ErrCodeNotFound, checksum)
+	}
+	if err != nil {
+		return err
+	}
+	// one modules directory per module serialization version
+	modules, err := filepath.Glob(filepath.Join(cache.dataDir, "cache", "modules", "*", name))
+	if err != nil {
+		return err
+	}
+	for _, module := range modules {
+		if err := os.Remove(module); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 func Pin(cache Cache, checksum []byte) error {
 	cs := makeView(checksum)
 	defer runtime.KeepAlive(checksum)
@@ -89,6 +333,19 @@ func Pin(cache Cache, checksum []byte) error {
 	return nil
 }
 
+// PinMany pins the given codes in order and stops at the first failure, which is returned as
+// types.PinManyError. Pinning a code that is already pinned is a no-op.
+//
+// libwasmvm does not provide a batch pin call, so this performs one FFI call per checksum.
+func PinMany(cache Cache, checksums [][]byte) error {
+	for i, checksum := range checksums {
+		if err := Pin(cache, checksum); err != nil {
+			return types.PinManyError{Checksum: checksum, Pinned: i, Err: err}
+		}
+	}
+	return nil
+}
+
 func Unpin(cache Cache, checksum []byte) error {
 	cs := makeView(checksum)
 	defer runtime.KeepAlive(checksum)
@@ -114,6 +371,13 @@ func AnalyzeCode(cache Cache, checksum []byte) (*types.AnalysisReport, error) {
 		RequiredFeatures:     requiredCapabilities,
 		RequiredCapabilities: requiredCapabilities,
 	}
+
+	// Static checks not provided by cosmwasm-vm are done on the Go side
+	wasm, err := GetCode(cache, checksum)
+	if err != nil {
+		return nil, err
+	}
+	addGoAnalysis(&res, wasm)
 	return &res, nil
 }
 
@@ -161,7 +425,7 @@ func Instantiate(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -171,9 +435,9 @@ func Instantiate(
 	res, err := C.instantiate(cache.ptr, cs, e, i, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func Execute(
@@ -201,7 +465,7 @@ func Execute(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -211,9 +475,9 @@ func Execute(
 	res, err := C.execute(cache.ptr, cs, e, i, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func Migrate(
@@ -238,7 +502,7 @@ func Migrate(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -248,9 +512,9 @@ func Migrate(
 	res, err := C.migrate(cache.ptr, cs, e, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func Sudo(
@@ -275,7 +539,7 @@ func Sudo(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -285,9 +549,9 @@ func Sudo(
 	res, err := C.sudo(cache.ptr, cs, e, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func Reply(
@@ -312,7 +576,7 @@ func Reply(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -322,9 +586,9 @@ func Reply(
 	res, err := C.reply(cache.ptr, cs, e, r, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func Query(
@@ -349,7 +613,7 @@ func Query(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -359,9 +623,9 @@ func Query(
 	res, err := C.query(cache.ptr, cs, e, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func IBCChannelOpen(
@@ -386,7 +650,7 @@ func IBCChannelOpen(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -396,9 +660,9 @@ func IBCChannelOpen(
 	res, err := C.ibc_channel_open(cache.ptr, cs, e, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func IBCChannelConnect(
@@ -423,7 +687,7 @@ func IBCChannelConnect(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -433,9 +697,9 @@ func IBCChannelConnect(
 	res, err := C.ibc_channel_connect(cache.ptr, cs, e, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func IBCChannelClose(
@@ -460,7 +724,7 @@ func IBCChannelClose(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -470,9 +734,9 @@ func IBCChannelClose(
 	res, err := C.ibc_channel_close(cache.ptr, cs, e, m, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func IBCPacketReceive(
@@ -497,7 +761,7 @@ func IBCPacketReceive(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -507,9 +771,9 @@ func IBCPacketReceive(
 	res, err := C.ibc_packet_receive(cache.ptr, cs, e, pa, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func IBCPacketAck(
@@ -534,7 +798,7 @@ func IBCPacketAck(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -544,9 +808,9 @@ func IBCPacketAck(
 	res, err := C.ibc_packet_ack(cache.ptr, cs, e, ac, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 func IBCPacketTimeout(
@@ -571,7 +835,7 @@ func IBCPacketTimeout(
 	callID := startCall()
 	defer endCall(callID)
 
-	dbState := buildDBState(store, callID)
+	dbState := buildDBState(store, callID, cache.iteratorGasCosts)
 	db := buildDB(&dbState, gasMeter)
 	a := buildAPI(api)
 	q := buildQuerier(querier)
@@ -581,9 +845,9 @@ func IBCPacketTimeout(
 	res, err := C.ibc_packet_timeout(cache.ptr, cs, e, pa, db, a, q, cu64(gasLimit), cbool(printDebug), &gasUsed, &errmsg)
 	if err != nil && err.(syscall.Errno) != C.ErrnoValue_Success {
 		// Depending on the nature of the error, `gasUsed` will either have a meaningful value, or just 0.
-		return nil, uint64(gasUsed), errorWithMessage(err, errmsg)
+		return nil, dbState.reportedGas(gasUsed), errorWithMessage(err, errmsg)
 	}
-	return copyAndDestroyUnmanagedVector(res), uint64(gasUsed), nil
+	return copyAndDestroyUnmanagedVector(res), dbState.reportedGas(gasUsed), nil
 }
 
 /**** To error module ***/
@@ -597,5 +861,42 @@ func errorWithMessage(err error, b C.UnmanagedVector) error {
 	if msg == nil {
 		return err
 	}
-	return fmt.Errorf("%s", string(msg))
+	// see RustError::Panic in libwasmvm/src/error/rust.rs. The panic output itself is not part of the
+	// message, the Rust panic handler prints it to stderr.
+	if string(msg) == "Caught panic" {
+		return types.ErrInternalPanic{Message: string(msg)}
+	}
+	return vmError(string(msg))
+}
+
+// prefix of RustError::VmErr, see libwasmvm/src/error/rust.rs
+const vmErrorPrefix = "Error calling the VM: "
+
+// messages of cosmwasm-vm's VmError and CommunicationError variants for invalid memory regions
+var regionErrorMessages = []string{
+	"Region length too big",
+	"Region too small",
+	"Region validation error",
+	"Region pointer is null",
+}
+
+// vmError categorizes an error message from libwasmvm into one of the typed VM errors.
+// Messages of other Rust errors (e.g. empty or invalid arguments) are returned as is.
+func vmError(msg string) error {
+	if !strings.HasPrefix(msg, vmErrorPrefix) {
+		return fmt.Errorf("%s", msg)
+	}
+	// see VmError::Aborted in cosmwasm-vm, raised by the abort import
+	if strings.Contains(msg, "RuntimeError: Aborted: ") {
+		return types.ErrContractPanic{Msg: msg}
+	}
+	if strings.Contains(msg, "Error calling into the VM's backend: ") {
+		return types.BackendError{Msg: msg}
+	}
+	for _, regionMsg := range regionErrorMessages {
+		if strings.Contains(msg, regionMsg) {
+			return types.RegionError{Msg: msg}
+		}
+	}
+	return types.VMError{Msg: msg}
 }
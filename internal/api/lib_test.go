@@ -1,10 +1,16 @@
 package api
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -44,6 +50,33 @@ func TestInitCacheEmptyFeatures(t *testing.T) {
 	ReleaseCache(cache)
 }
 
+func TestInitCacheVersionFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	cache, err := InitCache(tmpdir, TESTING_FEATURES, TESTING_CACHE_SIZE, TESTING_MEMORY_LIMIT)
+	require.NoError(t, err)
+	ReleaseCache(cache)
+	version, err := LibwasmvmVersion()
+	require.NoError(t, err)
+	cacheVersion, err := os.ReadFile(filepath.Join(tmpdir, CacheVersionFile))
+	require.NoError(t, err)
+	require.Equal(t, version, string(cacheVersion))
+
+	// a cache with modules but without version file is not marked
+	tmpdir2, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir2)
+	modules := filepath.Join(tmpdir2, "cache", "modules", "v3-wasmer1")
+	require.NoError(t, os.MkdirAll(modules, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(modules, "module"), []byte("compiled"), 0o644))
+	cache, err = InitCache(tmpdir2, TESTING_FEATURES, TESTING_CACHE_SIZE, TESTING_MEMORY_LIMIT)
+	require.NoError(t, err)
+	ReleaseCache(cache)
+	_, err = os.Stat(filepath.Join(tmpdir2, CacheVersionFile))
+	require.True(t, os.IsNotExist(err))
+}
+
 func withCache(t *testing.T) (Cache, func()) {
 	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
 	require.NoError(t, err)
@@ -113,6 +146,8 @@ func TestPinErrors(t *testing.T) {
 	brokenChecksum := []byte{0x3f, 0xd7, 0x5a, 0x76}
 	err = Pin(cache, brokenChecksum)
 	require.ErrorContains(t, err, "Checksum not of length 32")
+	var vmErr types.VMError
+	require.ErrorAs(t, err, &vmErr)
 
 	// Unknown checksum (errors in cosmwasm-vm)
 	unknownChecksum := []byte{
@@ -184,7 +219,7 @@ func TestGetMetrics(t *testing.T) {
 	assert.Equal(t, &types.Metrics{}, metrics)
 
 	// Instantiate 1
-	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter := GasMeter(gasMeter)
 	store := NewLookup(gasMeter)
 	api := NewMockAPI()
@@ -288,7 +323,7 @@ func TestInstantiate(t *testing.T) {
 	checksum, err := Create(cache, wasm)
 	require.NoError(t, err)
 
-	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter := GasMeter(gasMeter)
 	// instantiate it with this store
 	store := NewLookup(gasMeter)
@@ -310,12 +345,35 @@ func TestInstantiate(t *testing.T) {
 	require.Equal(t, 0, len(result.Ok.Messages))
 }
 
-func TestExecute(t *testing.T) {
+func TestInstantiateMinimalContract(t *testing.T) {
 	cache, cleanup := withCache(t)
 	defer cleanup()
-	checksum := createTestContract(t, cache)
 
-	gasMeter1 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	checksum, err := Create(cache, MinimalContract())
+	require.NoError(t, err)
+	report, err := AnalyzeCode(cache, checksum)
+	require.NoError(t, err)
+	require.Equal(t, "", report.RequiredCapabilities)
+
+	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	igasMeter := GasMeter(gasMeter)
+	store := NewLookup(gasMeter)
+	api := NewMockAPI()
+	querier := DefaultQuerier(MOCK_CONTRACT_ADDR, types.Coins{})
+	env := MockEnvBin(t)
+	info := MockInfoBin(t, "creator")
+
+	res, cost, err := Instantiate(cache, checksum, env, info, []byte(`{}`), &igasMeter, store, api, &querier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+	require.NoError(t, err)
+	requireOkResponse(t, res, 0)
+	require.NotZero(t, cost)
+}
+
+func TestExecute(t *testing.T) {
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
+
+	gasMeter1 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter1 := GasMeter(gasMeter1)
 	// instantiate it with this store
 	store := NewLookup(gasMeter1)
@@ -336,7 +394,7 @@ func TestExecute(t *testing.T) {
 	t.Logf("Time (%d gas): %s\n", cost, diff)
 
 	// execute with the same store
-	gasMeter2 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter2 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter2 := GasMeter(gasMeter2)
 	store.SetGasMeter(gasMeter2)
 	env = MockEnvBin(t)
@@ -375,11 +433,10 @@ func TestExecute(t *testing.T) {
 }
 
 func TestExecuteCpuLoop(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
-	gasMeter1 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter1 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter1 := GasMeter(gasMeter1)
 	// instantiate it with this store
 	store := NewLookup(gasMeter1)
@@ -414,9 +471,8 @@ func TestExecuteCpuLoop(t *testing.T) {
 }
 
 func TestExecuteStorageLoop(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
 	maxGas := TESTING_GAS_LIMIT
 	gasMeter1 := NewMockGasMeter(maxGas)
@@ -454,9 +510,8 @@ func TestExecuteStorageLoop(t *testing.T) {
 }
 
 func TestExecuteUserErrorsInApiCalls(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
 	maxGas := TESTING_GAS_LIMIT
 	gasMeter1 := NewMockGasMeter(maxGas)
@@ -485,11 +540,10 @@ func TestExecuteUserErrorsInApiCalls(t *testing.T) {
 }
 
 func TestMigrate(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
-	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter := GasMeter(gasMeter)
 	// instantiate it with this store
 	store := NewLookup(gasMeter)
@@ -530,12 +584,11 @@ func TestMigrate(t *testing.T) {
 }
 
 func TestMultipleInstances(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
 	// instance1 controlled by fred
-	gasMeter1 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter1 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter1 := GasMeter(gasMeter1)
 	store1 := NewLookup(gasMeter1)
 	api := NewMockAPI()
@@ -550,7 +603,7 @@ func TestMultipleInstances(t *testing.T) {
 	assert.Equal(t, uint64(0x140fd2fdc), cost)
 
 	// instance2 controlled by mary
-	gasMeter2 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter2 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter2 := GasMeter(gasMeter2)
 	store2 := NewLookup(gasMeter2)
 	info = MockInfoBin(t, "chrous")
@@ -584,11 +637,10 @@ func TestMultipleInstances(t *testing.T) {
 }
 
 func TestSudo(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
-	gasMeter1 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter1 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter1 := GasMeter(gasMeter1)
 	// instantiate it with this store
 	store := NewLookup(gasMeter1)
@@ -604,7 +656,7 @@ func TestSudo(t *testing.T) {
 	requireOkResponse(t, res, 0)
 
 	// call sudo with same store
-	gasMeter2 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter2 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter2 := GasMeter(gasMeter2)
 	store.SetGasMeter(gasMeter2)
 	env = MockEnvBin(t)
@@ -628,11 +680,10 @@ func TestSudo(t *testing.T) {
 }
 
 func TestDispatchSubmessage(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createReflectContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["reflect"]
 
-	gasMeter1 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter1 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter1 := GasMeter(gasMeter1)
 	// instantiate it with this store
 	store := NewLookup(gasMeter1)
@@ -660,7 +711,7 @@ func TestDispatchSubmessage(t *testing.T) {
 	require.NoError(t, err)
 	payloadMsg := []byte(fmt.Sprintf(`{"reflect_sub_msg":{"msgs":[%s]}}`, string(payloadBin)))
 
-	gasMeter2 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter2 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter2 := GasMeter(gasMeter2)
 	store.SetGasMeter(gasMeter2)
 	env = MockEnvBin(t)
@@ -681,11 +732,10 @@ func TestDispatchSubmessage(t *testing.T) {
 }
 
 func TestReplyAndQuery(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createReflectContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["reflect"]
 
-	gasMeter1 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter1 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter1 := GasMeter(gasMeter1)
 	// instantiate it with this store
 	store := NewLookup(gasMeter1)
@@ -720,7 +770,7 @@ func TestReplyAndQuery(t *testing.T) {
 	replyBin, err := json.Marshal(reply)
 	require.NoError(t, err)
 
-	gasMeter2 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter2 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter2 := GasMeter(gasMeter2)
 	store.SetGasMeter(gasMeter2)
 	env = MockEnvBin(t)
@@ -796,7 +846,7 @@ func createContract(t *testing.T, cache Cache, wasmFile string) []byte {
 
 // exec runs the handle tx with the given signer
 func exec(t *testing.T, cache Cache, checksum []byte, signer types.HumanAddress, store KVStore, api *GoAPI, querier Querier, gasExpected uint64) types.ContractResult {
-	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter := GasMeter(gasMeter)
 	env := MockEnvBin(t)
 	info := MockInfoBin(t, signer)
@@ -811,12 +861,11 @@ func exec(t *testing.T, cache Cache, checksum []byte, signer types.HumanAddress,
 }
 
 func TestQuery(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
 	// set up contract
-	gasMeter1 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter1 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter1 := GasMeter(gasMeter1)
 	store := NewLookup(gasMeter1)
 	api := NewMockAPI()
@@ -828,7 +877,7 @@ func TestQuery(t *testing.T) {
 	require.NoError(t, err)
 
 	// invalid query
-	gasMeter2 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter2 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter2 := GasMeter(gasMeter2)
 	store.SetGasMeter(gasMeter2)
 	query := []byte(`{"Raw":{"val":"config"}}`)
@@ -840,7 +889,7 @@ func TestQuery(t *testing.T) {
 	require.Contains(t, badResp.Err, "Error parsing into type hackatom::msg::QueryMsg: unknown variant `Raw`, expected one of")
 
 	// make a valid query
-	gasMeter3 := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter3 := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter3 := GasMeter(gasMeter3)
 	store.SetGasMeter(gasMeter3)
 	query = []byte(`{"verifier":{}}`)
@@ -853,13 +902,40 @@ func TestQuery(t *testing.T) {
 	require.Equal(t, string(qres.Ok), `{"verifier":"fred"}`)
 }
 
+func TestReadOnlyStore(t *testing.T) {
+	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := NewLookup(gasMeter)
+	store.Set([]byte("foo"), []byte("bar"))
+
+	readOnly := ReadOnlyStore(store)
+	require.Equal(t, []byte("bar"), readOnly.Get([]byte("foo")))
+	iter := readOnly.Iterator(nil, nil)
+	require.True(t, iter.Valid())
+	require.Equal(t, []byte("foo"), iter.Key())
+	iter.Close()
+	require.PanicsWithValue(t, ErrorReadOnlyStore{Op: "Set"}, func() { readOnly.Set([]byte("foo"), []byte("baz")) })
+	require.PanicsWithValue(t, ErrorReadOnlyStore{Op: "Delete"}, func() { readOnly.Delete([]byte("foo")) })
+	require.Equal(t, []byte("bar"), store.Get([]byte("foo")))
+
+	// a contract writing to the store fails
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
+	igasMeter := GasMeter(gasMeter)
+	api := NewMockAPI()
+	querier := DefaultQuerier(MOCK_CONTRACT_ADDR, types.Coins{})
+	env := MockEnvBin(t)
+	info := MockInfoBin(t, "creator")
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := Instantiate(cache, checksum, env, info, msg, &igasMeter, ReadOnlyStore(NewLookup(gasMeter)), api, &querier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+	require.ErrorContains(t, err, "Set called on read-only store")
+}
+
 func TestHackatomQuerier(t *testing.T) {
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createTestContract(t, cache)
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
 
 	// set up contract
-	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter := GasMeter(gasMeter)
 	store := NewLookup(gasMeter)
 	api := NewMockAPI()
@@ -879,41 +955,71 @@ func TestHackatomQuerier(t *testing.T) {
 	var balances types.AllBalancesResponse
 	err = json.Unmarshal(qres.Ok, &balances)
 	require.Equal(t, balances.Amount, initBalance)
+	bank := querier.(*MockQuerier).Bank
+	for _, denom := range bank.Denoms("foobar") {
+		require.Contains(t, balances.Amount, bank.Balance("foobar", denom))
+	}
 }
 
-func TestCustomReflectQuerier(t *testing.T) {
-	type CapitalizedQuery struct {
-		Text string `json:"text"`
-	}
+func TestBankQuerierFixtures(t *testing.T) {
+	bank := NewBankQuerier(map[string]types.Coins{
+		"alice": {types.NewCoin(5, "uatom"), types.NewCoin(12, "stake"), types.NewCoin(7, "ibc/ABC")},
+		"bob":   {types.NewCoin(1, "stake")},
+	})
+	require.Equal(t, []string{"ibc/ABC", "stake", "uatom"}, bank.Denoms("alice"))
+	require.Equal(t, []string{"stake"}, bank.Denoms("bob"))
+	require.Equal(t, []string{}, bank.Denoms("carol"))
+
+	require.Equal(t, types.NewCoin(12, "stake"), bank.Balance("alice", "stake"))
+	require.Equal(t, types.NewCoin(1, "stake"), bank.Balance("bob", "stake"))
+	require.Equal(t, types.NewCoin(0, "uatom"), bank.Balance("bob", "uatom"))
+	require.Equal(t, types.NewCoin(0, "stake"), bank.Balance("carol", "stake"))
+}
 
-	type QueryMsg struct {
-		Capitalized *CapitalizedQuery `json:"capitalized,omitempty"`
-		// There are more queries but we don't use them yet
-		// https://github.com/Finschia/cosmwasm/blob/v0.14.0-0.4.0/contracts/reflect/src/msg.rs#L38-L57
-	}
+func TestMockQuerierGas(t *testing.T) {
+	request := types.QueryRequest{Bank: &types.BankQuery{AllBalances: &types.AllBalancesQuery{Address: "foobar"}}}
+	marshaled, err := json.Marshal(request)
+	require.NoError(t, err)
 
-	type CapitalizedResponse struct {
-		Text string `json:"text"`
-	}
+	querier := NewMockQuerier("foobar", types.Coins{types.NewCoin(1234, "ATOM")}, 7)
+	_, err = querier.Query(request, DEFAULT_QUERIER_GAS_LIMIT)
+	require.NoError(t, err)
+	require.Equal(t, 7*uint64(len(marshaled)), querier.GasConsumed())
+	_, err = querier.Query(request, DEFAULT_QUERIER_GAS_LIMIT)
+	require.NoError(t, err)
+	require.Equal(t, 14*uint64(len(marshaled)), querier.GasConsumed())
 
-	cache, cleanup := withCache(t)
-	defer cleanup()
-	checksum := createReflectContract(t, cache)
+	// gas is consumed by queries from a contract
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
+	igasMeter := GasMeter(gasMeter)
+	store := NewLookup(gasMeter)
+	api := NewMockAPI()
+	querier = NewMockQuerier("foobar", nil, 1000)
+	iquerier := Querier(querier)
+	query := []byte(`{"other_balance":{"address":"foobar"}}`)
+	env := MockEnvBin(t)
+	_, _, err = Query(cache, checksum, env, query, &igasMeter, store, api, &iquerier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+	require.NoError(t, err)
+	require.Equal(t, 1000*uint64(len(marshaled)), querier.GasConsumed())
+}
+
+func TestCustomReflectQuerier(t *testing.T) {
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["reflect"]
 
 	// set up contract
-	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
 	igasMeter := GasMeter(gasMeter)
 	store := NewLookup(gasMeter)
 	api := NewMockAPI()
 	initBalance := types.Coins{types.NewCoin(1234, "ATOM")}
-	querier := DefaultQuerier(MOCK_CONTRACT_ADDR, initBalance)
 	// we need this to handle the custom requests from the reflect contract
-	innerQuerier := querier.(MockQuerier)
-	innerQuerier.Custom = ReflectCustom{}
-	querier = Querier(innerQuerier)
+	querier := Querier(NewReflectQuerier(MOCK_CONTRACT_ADDR, initBalance))
 
 	// make a valid query to the other address
-	queryMsg := QueryMsg{
+	queryMsg := ReflectQueryMsg{
 		Capitalized: &CapitalizedQuery{
 			Text: "small Frys :)",
 		},
@@ -928,8 +1034,232 @@ func TestCustomReflectQuerier(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "", qres.Err)
 
-	var response CapitalizedResponse
+	var response ReflectCapitalizedResponse
 	err = json.Unmarshal(qres.Ok, &response)
 	require.NoError(t, err)
 	require.Equal(t, "SMALL FRYS :)", response.Text)
 }
+
+func TestCustomQueryRegistry(t *testing.T) {
+	registry := CustomQueryRegistry{
+		"ping": CustomQuerierFunc(func(request json.RawMessage) ([]byte, error) {
+			return json.Marshal(CustomResponse{Msg: "PONG"})
+		}),
+		"capitalized": CustomQuerierFunc(func(request json.RawMessage) ([]byte, error) {
+			var query CapitalizedQuery
+			err := json.Unmarshal(request, &query)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(CustomResponse{Msg: strings.ToUpper(query.Text)})
+		}),
+	}
+
+	// dispatch to both handlers directly
+	bz, err := registry.Query(json.RawMessage(`{"ping":{}}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"msg":"PONG"}`, string(bz))
+	bz, err = registry.Query(json.RawMessage(`{"capitalized":{"text":"small."}}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"msg":"SMALL."}`, string(bz))
+
+	// unknown namespace
+	_, err = registry.Query(json.RawMessage(`{"other":{}}`))
+	require.Equal(t, types.UnsupportedRequest{Kind: "custom namespace other"}, err)
+
+	// dispatch from the reflect contract
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["reflect"]
+
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
+	igasMeter := GasMeter(gasMeter)
+	store := NewLookup(gasMeter)
+	api := NewMockAPI()
+	innerQuerier := DefaultQuerier(MOCK_CONTRACT_ADDR, nil).(*MockQuerier)
+	innerQuerier.Custom = registry
+	querier := Querier(innerQuerier)
+
+	env := MockEnvBin(t)
+	query := []byte(`{"capitalized":{"text":"small Frys :)"}}`)
+	data, _, err := Query(cache, checksum, env, query, &igasMeter, store, api, &querier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+	require.NoError(t, err)
+	qres := requireQueryOk(t, data)
+	require.Equal(t, `{"text":"SMALL FRYS :)"}`, string(qres))
+}
+
+func TestErrorWithMessage(t *testing.T) {
+	// libwasmvm reports every caught panic with this fixed message, without the panic output
+	err := errorWithMessage(fmt.Errorf("errno"), newUnmanagedVector([]byte("Caught panic")))
+	require.Equal(t, types.ErrInternalPanic{Message: "Caught panic"}, err)
+	require.Equal(t, "Caught panic", err.Error())
+
+	err = errorWithMessage(fmt.Errorf("errno"), newUnmanagedVector([]byte("Error calling the VM: boom")))
+	require.Equal(t, types.VMError{Msg: "Error calling the VM: boom"}, err)
+	require.EqualError(t, err, "Error calling the VM: boom")
+
+	msg := "Error calling the VM: Error executing Wasm: Wasmer runtime error: RuntimeError: Error calling into the VM's backend: Panic in FFI call"
+	err = errorWithMessage(fmt.Errorf("errno"), newUnmanagedVector([]byte(msg)))
+	require.Equal(t, types.BackendError{Msg: msg}, err)
+	require.EqualError(t, err, msg)
+
+	msg = "Error calling the VM: Error executing Wasm: Wasmer runtime error: RuntimeError: Region length too big. Got 5000000, limit 4000000"
+	err = errorWithMessage(fmt.Errorf("errno"), newUnmanagedVector([]byte(msg)))
+	require.Equal(t, types.RegionError{Msg: msg}, err)
+	require.EqualError(t, err, msg)
+
+	msg = "Error calling the VM: Error executing Wasm: Wasmer runtime error: RuntimeError: Aborted: panicked at 'boom'"
+	err = errorWithMessage(fmt.Errorf("errno"), newUnmanagedVector([]byte(msg)))
+	require.Equal(t, types.ErrContractPanic{Msg: msg}, err)
+
+	// other Rust errors are not categorized
+	err = errorWithMessage(fmt.Errorf("errno"), newUnmanagedVector([]byte("Null/Nil argument: checksum")))
+	require.EqualError(t, err, "Null/Nil argument: checksum")
+	require.False(t, errors.As(err, &types.VMError{}))
+
+	err = errorWithMessage(syscall.Errno(2), newUnmanagedVector([]byte("Ran out of gas")))
+	require.Equal(t, types.OutOfGasError{}, err)
+
+	err = errorWithMessage(fmt.Errorf("errno"), newUnmanagedVector(nil))
+	require.EqualError(t, err, "errno")
+}
+
+func TestAddressPreCheck(t *testing.T) {
+	cache, checksums := SharedFixtureCache(t)
+	checksum := checksums["hackatom"]
+
+	gasMeter := NewAssertingGasMeter(t, TESTING_GAS_LIMIT)
+	igasMeter := GasMeter(gasMeter)
+	store := NewLookup(gasMeter)
+	api := NewMockAPI()
+	// a chain using 0x prefixed hex addresses
+	api.AddressPreCheck = func(human string) error {
+		if !strings.HasPrefix(human, "0x") {
+			return fmt.Errorf("missing 0x prefix")
+		}
+		if _, err := hex.DecodeString(human[2:]); err != nil {
+			return fmt.Errorf("invalid hex address: %w", err)
+		}
+		return nil
+	}
+	querier := DefaultQuerier(MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(100, "ATOM")})
+	env := MockEnvBin(t)
+	info := MockInfoBin(t, "creator")
+
+	msg := []byte(`{"verifier": "0xf00d", "beneficiary": "0xbeef"}`)
+	res, _, err := Instantiate(cache, checksum, env, info, msg, &igasMeter, store, api, &querier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+	require.NoError(t, err)
+	requireOkResponse(t, res, 0)
+
+	msg = []byte(`{"verifier": "fred", "beneficiary": "0xbeef"}`)
+	res, _, err = Instantiate(cache, checksum, env, info, msg, &igasMeter, store, api, &querier, TESTING_GAS_LIMIT, TESTING_PRINT_DEBUG)
+	require.NoError(t, err)
+	var result types.ContractResult
+	err = json.Unmarshal(res, &result)
+	require.NoError(t, err)
+	require.Contains(t, result.Err, "missing 0x prefix")
+}
+
+// recordingTB records errors reported via Errorf instead of failing the test
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertingGasMeter(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	meter := NewAssertingGasMeter(tb, 100)
+	meter.ConsumeGas(60, "first")
+	require.Equal(t, Gas(60), meter.GasConsumed())
+	require.Empty(t, tb.errors)
+
+	// a deliberate violation of the limit
+	require.PanicsWithValue(t, ErrorOutOfGas{"second"}, func() {
+		meter.ConsumeGas(50, "second")
+	})
+	require.Equal(t, []string{"consuming 50 gas for second exceeds the limit of 100"}, tb.errors)
+
+	// decreasing consumption
+	tb = &recordingTB{TB: t}
+	asserting := NewAssertingGasMeter(tb, 100).(*assertingGasMeter)
+	asserting.ConsumeGas(30, "first")
+	asserting.GasConsumed()
+	asserting.consumed = 20
+	asserting.GasConsumed()
+	require.Equal(t, []string{"gas consumption decreased from 30 to 20"}, tb.errors)
+}
+
+func TestValidateBankSends(t *testing.T) {
+	send := func(coins ...types.Coin) types.SubMsg {
+		return types.SubMsg{Msg: types.CosmosMsg{Bank: &types.BankMsg{Send: &types.SendMsg{ToAddress: "bob", Amount: coins}}}}
+	}
+	resp := &types.Response{
+		Messages: []types.SubMsg{
+			send(types.NewCoin(300, "ATOM")),
+			{Msg: types.CosmosMsg{Bank: &types.BankMsg{Burn: &types.BurnMsg{Amount: types.Coins{types.NewCoin(5000, "ATOM")}}}}},
+			send(types.NewCoin(200, "ATOM"), types.NewCoin(7, "ETH")),
+		},
+	}
+
+	err := ValidateBankSends(resp, types.Coins{types.NewCoin(500, "ATOM"), types.NewCoin(10, "ETH")})
+	require.NoError(t, err)
+
+	err = ValidateBankSends(resp, types.Coins{types.NewCoin(499, "ATOM"), types.NewCoin(10, "ETH")})
+	require.ErrorIs(t, err, types.ErrInsufficientContractBalance)
+	require.EqualError(t, err, "insufficient contract balance: sending 500ATOM but only 499ATOM available")
+
+	// no balance at all in a denom
+	err = ValidateBankSends(resp, types.Coins{types.NewCoin(500, "ATOM")})
+	require.EqualError(t, err, "insufficient contract balance: sending 7ETH but only 0ETH available")
+
+	// duplicate denoms in the balance are added up
+	err = ValidateBankSends(resp, types.Coins{types.NewCoin(300, "ATOM"), types.NewCoin(10, "ETH"), types.NewCoin(200, "ATOM")})
+	require.NoError(t, err)
+	err = ValidateBankSends(resp, types.Coins{types.NewCoin(300, "ATOM"), types.NewCoin(10, "ETH"), types.NewCoin(199, "ATOM")})
+	require.EqualError(t, err, "insufficient contract balance: sending 500ATOM but only 499ATOM available")
+
+	// nothing sent
+	err = ValidateBankSends(&types.Response{}, nil)
+	require.NoError(t, err)
+
+	// no response
+	err = ValidateBankSends(nil, nil)
+	require.EqualError(t, err, "no response to validate")
+}
+
+// gasMeterWithoutLimit is a GasMeter that does not know its limit
+type gasMeterWithoutLimit struct{}
+
+func (gasMeterWithoutLimit) GasConsumed() Gas {
+	return 1000
+}
+
+// gasMeterWithGasRemaining reports the remaining gas itself
+type gasMeterWithGasRemaining struct {
+	gasMeterWithoutLimit
+}
+
+func (gasMeterWithGasRemaining) GasRemaining() Gas {
+	return 42
+}
+
+func TestRemainingGas(t *testing.T) {
+	gasMeter := NewMockGasMeter(1000)
+	require.Equal(t, uint64(1000), RemainingGas(gasMeter))
+	gasMeter.ConsumeGas(300, "test")
+	require.Equal(t, uint64(700), RemainingGas(gasMeter))
+	gasMeter.ConsumeGas(700, "test")
+	require.Equal(t, uint64(0), RemainingGas(gasMeter))
+
+	assertingMeter := NewAssertingGasMeter(t, 500)
+	assertingMeter.ConsumeGas(200, "test")
+	require.Equal(t, uint64(300), RemainingGas(assertingMeter))
+
+	require.Equal(t, uint64(math.MaxUint64), RemainingGas(gasMeterWithoutLimit{}))
+	// like the infinite gas meter of finschia-sdk
+	require.Equal(t, uint64(math.MaxUint64), RemainingGas(NewMockGasMeter(0)))
+	require.Equal(t, uint64(42), RemainingGas(gasMeterWithGasRemaining{}))
+}
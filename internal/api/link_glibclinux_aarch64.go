@@ -5,3 +5,6 @@ package api
 
 // #cgo LDFLAGS: -Wl,-rpath,${SRCDIR} -L${SRCDIR} -lwasmvm.aarch64
 import "C"
+
+// libwasmvmTarget is the Rust target triple of the linked library (see LibwasmvmBuildInfo)
+const libwasmvmTarget = "aarch64-unknown-linux-gnu"
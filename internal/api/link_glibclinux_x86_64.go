@@ -5,3 +5,6 @@ package api
 
 // #cgo LDFLAGS: -Wl,-rpath,${SRCDIR} -L${SRCDIR} -lwasmvm.x86_64
 import "C"
+
+// libwasmvmTarget is the Rust target triple of the linked library (see LibwasmvmBuildInfo)
+const libwasmvmTarget = "x86_64-unknown-linux-gnu"
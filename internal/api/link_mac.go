@@ -4,3 +4,7 @@ package api
 
 // #cgo LDFLAGS: -Wl,-rpath,${SRCDIR} -L${SRCDIR} -lwasmvm
 import "C"
+
+// libwasmvmTarget is the Rust target triple of the linked library (see LibwasmvmBuildInfo).
+// The universal library contains both architectures, of which the one matching the Go binary is loaded.
+var libwasmvmTarget = rustArch() + "-apple-darwin"
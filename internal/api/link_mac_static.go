@@ -4,3 +4,7 @@ package api
 
 // #cgo LDFLAGS: -L${SRCDIR} -lwasmvmstatic_darwin
 import "C"
+
+// libwasmvmTarget is the Rust target triple of the linked library (see LibwasmvmBuildInfo).
+// The universal library contains both architectures, of which the one matching the Go binary is linked.
+var libwasmvmTarget = rustArch() + "-apple-darwin"
@@ -5,3 +5,6 @@ package api
 
 // #cgo LDFLAGS: -Wl,-rpath,${SRCDIR} -L${SRCDIR} -lwasmvm_muslc
 import "C"
+
+// libwasmvmTarget is the Rust target triple of the linked library (see LibwasmvmBuildInfo)
+var libwasmvmTarget = rustArch() + "-unknown-linux-musl"
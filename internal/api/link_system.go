@@ -4,3 +4,7 @@ package api
 
 // #cgo LDFLAGS: -lwasmvm
 import "C"
+
+// libwasmvmTarget is the Rust target triple of the linked library (see LibwasmvmBuildInfo).
+// It is unknown for a library provided by the system.
+const libwasmvmTarget = ""
@@ -4,3 +4,6 @@ package api
 
 // #cgo LDFLAGS: -Wl,-rpath,${SRCDIR} -L${SRCDIR} -lwasmvm
 import "C"
+
+// libwasmvmTarget is the Rust target triple of the linked library (see LibwasmvmBuildInfo)
+const libwasmvmTarget = "x86_64-pc-windows-gnu"
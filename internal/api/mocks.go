@@ -1,10 +1,13 @@
 package api
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
+	"math/big"
+	"sort"
 	"strings"
 	"testing"
 
@@ -240,6 +243,44 @@ func (g *mockGasMeter) ConsumeGas(amount Gas, descriptor string) {
 	}
 }
 
+// assertingGasMeter is a mockGasMeter that fails the test on invalid gas accounting
+type assertingGasMeter struct {
+	mockGasMeter
+	t testing.TB
+	// lastConsumed is the consumption last reported by GasConsumed
+	lastConsumed Gas
+}
+
+// NewAssertingGasMeter returns a MockGasMeter that fails the test if consuming gas overflows,
+// exceeds the limit or if the reported consumption ever decreases. Use it in tests that are
+// not expected to run out of gas. Like the MockGasMeter, it still panics on overflow and out of gas.
+func NewAssertingGasMeter(t testing.TB, limit Gas) MockGasMeter {
+	return &assertingGasMeter{
+		mockGasMeter: mockGasMeter{limit: limit},
+		t:            t,
+	}
+}
+
+func (g *assertingGasMeter) GasConsumed() Gas {
+	consumed := g.mockGasMeter.GasConsumed()
+	if consumed < g.lastConsumed {
+		g.t.Errorf("gas consumption decreased from %d to %d", g.lastConsumed, consumed)
+	}
+	g.lastConsumed = consumed
+	return consumed
+}
+
+func (g *assertingGasMeter) ConsumeGas(amount Gas, descriptor string) {
+	// Errorf instead of Fatalf since this may be called from a callback of the VM
+	consumed, overflow := addUint64Overflow(g.consumed, amount)
+	if overflow {
+		g.t.Errorf("consuming %d gas for %s overflows", amount, descriptor)
+	} else if consumed > g.limit {
+		g.t.Errorf("consuming %d gas for %s exceeds the limit of %d", amount, descriptor, g.limit)
+	}
+	g.mockGasMeter.ConsumeGas(amount, descriptor)
+}
+
 /*** Mock KVStore ****/
 // Much of this code is borrowed from finschia-sdk store/transient.go
 
@@ -254,6 +295,8 @@ const (
 	RangePrice         = 261000
 )
 
+// Lookup is a KVStore backed by an in-memory B-tree, so iterators return keys in sorted order,
+// independent of the insertion order.
 type Lookup struct {
 	db    *dbm.MemDB
 	meter MockGasMeter
@@ -328,6 +371,60 @@ func (l Lookup) ReverseIterator(start, end []byte) dbm.Iterator {
 
 var _ KVStore = (*Lookup)(nil)
 
+// Export serializes all entries of the store in key order. Each key and value is
+// prefixed with its length as a 4 byte big endian integer. No gas is consumed.
+func (l Lookup) Export() ([]byte, error) {
+	iter, err := l.db.Iterator(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var out []byte
+	for ; iter.Valid(); iter.Next() {
+		out = appendLengthPrefixed(out, iter.Key())
+		out = appendLengthPrefixed(out, iter.Value())
+	}
+	return out, iter.Error()
+}
+
+// ImportLookup creates a store from data created by Lookup.Export
+func ImportLookup(data []byte, meter MockGasMeter) (*Lookup, error) {
+	lookup := NewLookup(meter)
+	for len(data) > 0 {
+		key, rest, err := readLengthPrefixed(data)
+		if err != nil {
+			return nil, err
+		}
+		value, rest, err := readLengthPrefixed(rest)
+		if err != nil {
+			return nil, err
+		}
+		if err := lookup.db.Set(key, value); err != nil {
+			return nil, err
+		}
+		data = rest
+	}
+	return lookup, nil
+}
+
+func appendLengthPrefixed(out []byte, data []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	return append(out, data...)
+}
+
+func readLengthPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, errors.New("missing length prefix")
+	}
+	length := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(len(data)) < uint64(length) {
+		return nil, nil, fmt.Errorf("expected %d bytes but only %d left", length, len(data))
+	}
+	return data[:length], data[length:], nil
+}
+
 /***** Mock GoAPI ****/
 
 const CanonicalLength = 32
@@ -386,30 +483,41 @@ func TestMockApi(t *testing.T) {
 const DEFAULT_QUERIER_GAS_LIMIT = 1_000_000
 
 type MockQuerier struct {
-	Bank    BankQuerier
-	Custom  CustomQuerier
-	usedGas uint64
+	Bank   BankQuerier
+	Custom CustomQuerier
+	// GasPerQueryByte is the gas charged per byte of the JSON encoded query request
+	GasPerQueryByte uint64
+	usedGas         uint64
 }
 
-var _ types.Querier = MockQuerier{}
+var _ types.Querier = &MockQuerier{}
 
+// DefaultQuerier creates a querier that does not charge gas. See NewMockQuerier.
 func DefaultQuerier(contractAddr string, coins types.Coins) Querier {
+	return NewMockQuerier(contractAddr, coins, 0)
+}
+
+// NewMockQuerier creates a querier with the given balance for contractAddr,
+// charging gasPerQueryByte gas for each byte of a JSON encoded query request.
+func NewMockQuerier(contractAddr string, coins types.Coins, gasPerQueryByte uint64) *MockQuerier {
 	balances := map[string]types.Coins{
 		contractAddr: coins,
 	}
-	return MockQuerier{
-		Bank:    NewBankQuerier(balances),
-		Custom:  NoCustom{},
-		usedGas: 0,
+	return &MockQuerier{
+		Bank:            NewBankQuerier(balances),
+		Custom:          NoCustom{},
+		GasPerQueryByte: gasPerQueryByte,
+		usedGas:         0,
 	}
 }
 
-func (q MockQuerier) Query(request types.QueryRequest, _gasLimit uint64) ([]byte, error) {
-	marshaled, err := json.Marshal(request)
-	if err != nil {
-		return nil, err
-	}
-	q.usedGas += uint64(len(marshaled))
+func (q *MockQuerier) Query(request types.QueryRequest, _gasLimit uint64) ([]byte, error) {
+	res, err := q.query(request)
+	q.usedGas += types.QueryResponseGas(request, res, types.QueryGasConfig{PerRequestByte: q.GasPerQueryByte})
+	return res, err
+}
+
+func (q *MockQuerier) query(request types.QueryRequest) ([]byte, error) {
 	if request.Bank != nil {
 		return q.Bank.Query(request.Bank)
 	}
@@ -425,7 +533,7 @@ func (q MockQuerier) Query(request types.QueryRequest, _gasLimit uint64) ([]byte
 	return nil, types.Unknown{}
 }
 
-func (q MockQuerier) GasConsumed() uint64 {
+func (q *MockQuerier) GasConsumed() uint64 {
 	return q.usedGas
 }
 
@@ -445,17 +553,31 @@ func NewBankQuerier(balances map[string]types.Coins) BankQuerier {
 	}
 }
 
+// Denoms returns the sorted denoms of the balance of addr
+func (q BankQuerier) Denoms(addr string) []string {
+	denoms := []string{}
+	for _, c := range q.Balances[addr] {
+		denoms = append(denoms, c.Denom)
+	}
+	sort.Strings(denoms)
+	return denoms
+}
+
+// Balance returns the balance of addr in denom, which is zero if there is none
+func (q BankQuerier) Balance(addr, denom string) types.Coin {
+	coin := types.NewCoin(0, denom)
+	for _, c := range q.Balances[addr] {
+		if c.Denom == denom {
+			coin = c
+		}
+	}
+	return coin
+}
+
 func (q BankQuerier) Query(request *types.BankQuery) ([]byte, error) {
 	if request.Balance != nil {
-		denom := request.Balance.Denom
-		coin := types.NewCoin(0, denom)
-		for _, c := range q.Balances[request.Balance.Address] {
-			if c.Denom == denom {
-				coin = c
-			}
-		}
 		resp := types.BalanceResponse{
-			Amount: coin,
+			Amount: q.Balance(request.Balance.Address, request.Balance.Denom),
 		}
 		return json.Marshal(resp)
 	}
@@ -469,6 +591,52 @@ func (q BankQuerier) Query(request *types.BankQuery) ([]byte, error) {
 	return nil, types.UnsupportedRequest{"Empty BankQuery"}
 }
 
+// ValidateBankSends checks that the Bank.Send messages of resp do not send more of any denom
+// than the contract holds according to balance. It returns types.ErrInsufficientContractBalance
+// for the first denom that is exceeded. This is a sanity check for simulations, which does not
+// consider funds moved by other messages. Amounts of the same denom listed more than once in
+// balance are added up.
+func ValidateBankSends(resp *types.Response, balance types.Coins) error {
+	if resp == nil {
+		return errors.New("no response to validate")
+	}
+	sent := make(map[string]*big.Int)
+	denoms := []string{}
+	for _, msg := range resp.Messages {
+		if msg.Msg.Bank == nil || msg.Msg.Bank.Send == nil {
+			continue
+		}
+		for _, coin := range msg.Msg.Bank.Send.Amount {
+			amount, ok := new(big.Int).SetString(coin.Amount, 10)
+			if !ok {
+				return fmt.Errorf("invalid amount %q of %s", coin.Amount, coin.Denom)
+			}
+			if _, ok := sent[coin.Denom]; !ok {
+				sent[coin.Denom] = new(big.Int)
+				denoms = append(denoms, coin.Denom)
+			}
+			sent[coin.Denom].Add(sent[coin.Denom], amount)
+		}
+	}
+	for _, denom := range denoms {
+		available := new(big.Int)
+		for _, coin := range balance {
+			if coin.Denom != denom {
+				continue
+			}
+			amount, ok := new(big.Int).SetString(coin.Amount, 10)
+			if !ok {
+				return fmt.Errorf("invalid balance %q of %s", coin.Amount, coin.Denom)
+			}
+			available.Add(available, amount)
+		}
+		if sent[denom].Cmp(available) > 0 {
+			return fmt.Errorf("%w: sending %s%s but only %s%s available", types.ErrInsufficientContractBalance, sent[denom], denom, available, denom)
+		}
+	}
+	return nil
+}
+
 type CustomQuerier interface {
 	Query(request json.RawMessage) ([]byte, error)
 }
@@ -481,6 +649,35 @@ func (q NoCustom) Query(request json.RawMessage) ([]byte, error) {
 	return nil, types.UnsupportedRequest{"custom"}
 }
 
+// CustomQuerierFunc allows using a plain function as CustomQuerier
+type CustomQuerierFunc func(request json.RawMessage) ([]byte, error)
+
+var _ CustomQuerier = CustomQuerierFunc(nil)
+
+func (f CustomQuerierFunc) Query(request json.RawMessage) ([]byte, error) {
+	return f(request)
+}
+
+// CustomQueryRegistry routes custom queries by namespace, i.e. the top-level key of the
+// query (see types.CustomQuery). The handler registered for the namespace receives the
+// namespace specific payload only.
+type CustomQueryRegistry map[string]CustomQuerier
+
+var _ CustomQuerier = CustomQueryRegistry{}
+
+func (r CustomQueryRegistry) Query(request json.RawMessage) ([]byte, error) {
+	var query types.CustomQuery
+	err := json.Unmarshal(request, &query)
+	if err != nil {
+		return nil, err
+	}
+	handler, ok := r[query.Namespace]
+	if !ok {
+		return nil, types.UnsupportedRequest{Kind: "custom namespace " + query.Namespace}
+	}
+	return handler.Query(query.Request)
+}
+
 // ReflectCustom fulfills the requirements for testing `reflect` contract
 type ReflectCustom struct{}
 
@@ -517,6 +714,27 @@ func (q ReflectCustom) Query(request json.RawMessage) ([]byte, error) {
 	return json.Marshal(resp)
 }
 
+// NewReflectQuerier creates a querier with the given balance for contractAddr that answers
+// the custom queries of the `reflect` contract
+func NewReflectQuerier(contractAddr string, coins types.Coins) *MockQuerier {
+	querier := NewMockQuerier(contractAddr, coins, 0)
+	querier.Custom = ReflectCustom{}
+	return querier
+}
+
+// ReflectQueryMsg contains the queries of the `reflect` contract used in tests.
+// See https://github.com/Finschia/cosmwasm/blob/v0.14.0-0.4.0/contracts/reflect/src/msg.rs#L38-L57
+// for all of them.
+type ReflectQueryMsg struct {
+	// Capitalized forwards the text to the chain as a custom query
+	Capitalized *CapitalizedQuery `json:"capitalized,omitempty"`
+}
+
+// ReflectCapitalizedResponse is the response of the `reflect` contract for ReflectQueryMsg.Capitalized
+type ReflectCapitalizedResponse struct {
+	Text string `json:"text"`
+}
+
 //************ test code for mocks *************************//
 
 func TestBankQuerierAllBalances(t *testing.T) {
@@ -632,3 +850,167 @@ func TestReflectCustomQuerier(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, resp2.Msg, "SMALL.")
 }
+
+/**** Minimal contract ****/
+
+// minimalContractResult is the result returned by the instantiate entry point of MinimalContract
+const minimalContractResult = `{"ok":{"messages":[],"attributes":[],"events":[]}}`
+
+// MinimalContract returns a tiny valid contract that can be stored and instantiated, for tests of
+// the plumbing that do not need a real contract. Its only entry point is instantiate, which ignores
+// its inputs and returns an empty response without touching the store.
+func MinimalContract() []byte {
+	return minimalContract(nil, minimalEntryPoint{name: "instantiate", arity: 3, result: minimalContractResult})
+}
+
+// MinimalIBCContract returns a tiny contract like MinimalContract, with an additional entry point
+// ibc_packet_receive returning the given JSON encoded IBCReceiveResult. This allows testing the
+// handling of responses that no real contract produces.
+func MinimalIBCContract(receiveResult string) []byte {
+	return minimalContract(nil,
+		minimalEntryPoint{name: "instantiate", arity: 3, result: minimalContractResult},
+		minimalEntryPoint{name: "ibc_packet_receive", arity: 2, result: receiveResult},
+	)
+}
+
+// MinimalFloatContract returns a tiny contract like MinimalContract which requires the given capabilities
+// and whose instantiate uses a floating point operation, such that libwasmvm rejects it when compiling.
+func MinimalFloatContract(capabilities ...string) []byte {
+	return minimalContract(capabilities, minimalEntryPoint{name: "instantiate", arity: 3, result: minimalContractResult, usesFloats: true})
+}
+
+// minimalEntryPoint is an entry point of a contract built by minimalContract, which takes arity
+// arguments, ignores them and returns result
+type minimalEntryPoint struct {
+	name   string
+	arity  int
+	result string
+	// usesFloats adds a floating point operation, which is not allowed in contracts
+	usesFloats bool
+}
+
+// minimalContract builds a contract with the given entry points, which exports a requires_* marker
+// for each of the given capabilities
+func minimalContract(capabilities []string, entryPoints ...minimalEntryPoint) []byte {
+	uleb := func(v uint32) []byte {
+		out := []byte{}
+		for {
+			b := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 {
+				return append(out, b)
+			}
+			out = append(out, b|0x80)
+		}
+	}
+	// sleb encodes non-negative values as signed LEB128, as needed for i32.const
+	sleb := func(v uint32) []byte {
+		out := []byte{}
+		for {
+			b := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 && b&0x40 == 0 {
+				return append(out, b)
+			}
+			out = append(out, b|0x80)
+		}
+	}
+	vec := func(items ...[]byte) []byte {
+		out := uleb(uint32(len(items)))
+		for _, item := range items {
+			out = append(out, item...)
+		}
+		return out
+	}
+	name := func(s string) []byte {
+		return append(uleb(uint32(len(s))), s...)
+	}
+	section := func(id byte, payload []byte) []byte {
+		return append(append([]byte{id}, uleb(uint32(len(payload)))...), payload...)
+	}
+	body := func(locals []byte, code ...byte) []byte {
+		b := append(locals, code...)
+		return append(uleb(uint32(len(b))), b...)
+	}
+	const i32 = 0x7f
+	// the result Regions {offset, capacity, length} of the entry points start at 16 and are followed
+	// by the results. The heap of the bump allocator starts at 1024.
+	const dataPtr, heapPtr = 16, 1024
+
+	signatures := [][]byte{
+		{0x60, 0x01, i32, 0x01, i32}, // 0: (i32) -> i32
+		{0x60, 0x01, i32, 0x00},      // 1: (i32) -> ()
+		{0x60, 0x00, 0x00},           // 2: () -> ()
+	}
+	// allocate, deallocate, interface_version_8
+	functions := [][]byte{{0}, {1}, {2}}
+	exports := [][]byte{
+		append(name("memory"), 0x02, 0x00),
+		append(name("allocate"), 0x00, 0x00),
+		append(name("deallocate"), 0x00, 0x01),
+		append(name("interface_version_8"), 0x00, 0x02),
+	}
+	for _, capability := range capabilities {
+		// markers are empty functions like interface_version_8
+		exports = append(exports, append(name("requires_"+capability), 0x00, 0x02))
+	}
+	bodies := [][]byte{
+		// allocate(size): creates a Region {offset, capacity, length} followed by its data
+		body([]byte{0x01, 0x01, i32},
+			0x23, 0x00, 0x21, 0x01, // region = global
+			0x20, 0x01, 0x20, 0x01, 0x41, 0x0c, 0x6a, 0x36, 0x02, 0x00, // region.offset = region + 12
+			0x20, 0x01, 0x20, 0x00, 0x36, 0x02, 0x04, // region.capacity = size
+			0x20, 0x01, 0x41, 0x00, 0x36, 0x02, 0x08, // region.length = 0
+			// global = region + 12 + size, aligned to 4 bytes since the VM expects aligned Regions
+			0x20, 0x01, 0x41, 0x0f, 0x6a, 0x20, 0x00, 0x6a, 0x41, 0x7c, 0x71, 0x24, 0x00,
+			0x20, 0x01, 0x0b),
+		// deallocate(ptr)
+		body([]byte{0x00}, 0x0b),
+		// interface_version_8()
+		body([]byte{0x00}, 0x0b),
+	}
+	regions := make([]byte, 12*len(entryPoints))
+	results := []byte{}
+	for i, entryPoint := range entryPoints {
+		params := make([][]byte, entryPoint.arity)
+		for j := range params {
+			params[j] = []byte{i32}
+		}
+		index := byte(len(signatures))
+		signatures = append(signatures, append(append([]byte{0x60}, vec(params...)...), 0x01, i32))
+		functions = append(functions, []byte{index})
+		exports = append(exports, append(name(entryPoint.name), 0x00, index))
+		// entry point: return the result Region
+		code := []byte{}
+		if entryPoint.usesFloats {
+			code = append(code, 0x43, 0x00, 0x00, 0x00, 0x00, 0x1a) // f32.const 0 drop
+		}
+		regionPtr := uint32(dataPtr + 12*i)
+		code = append(append(append(code, 0x41), sleb(regionPtr)...), 0x0b)
+		bodies = append(bodies, body([]byte{0x00}, code...))
+
+		resultPtr := uint32(dataPtr + len(regions) + len(results))
+		binary.LittleEndian.PutUint32(regions[12*i:], resultPtr)
+		binary.LittleEndian.PutUint32(regions[12*i+4:], uint32(len(entryPoint.result)))
+		binary.LittleEndian.PutUint32(regions[12*i+8:], uint32(len(entryPoint.result)))
+		results = append(results, entryPoint.result...)
+	}
+	data := append(regions, results...)
+	if dataPtr+len(data) > heapPtr {
+		panic("results overlap the heap")
+	}
+
+	wasm := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	wasm = append(wasm, section(1, vec(signatures...))...)
+	wasm = append(wasm, section(3, vec(functions...))...)
+	// one page of memory without maximum
+	wasm = append(wasm, section(5, vec([]byte{0x00, 0x01}))...)
+	// mutable i32 global for the bump allocator
+	wasm = append(wasm, section(6, vec(append(append([]byte{i32, 0x01, 0x41}, sleb(heapPtr)...), 0x0b)))...)
+	wasm = append(wasm, section(7, vec(exports...))...)
+	wasm = append(wasm, section(10, vec(bodies...))...)
+	wasm = append(wasm, section(11, vec(
+		append(append([]byte{0x00, 0x41, dataPtr, 0x0b}, uleb(uint32(len(data)))...), data...),
+	))...)
+	return wasm
+}
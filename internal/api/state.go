@@ -0,0 +1,38 @@
+package api
+
+import (
+	dbm "github.com/tendermint/tm-db"
+)
+
+// DumpContractState returns an iterator over all entries of store whose key starts with prefix,
+// in ascending key order. The keys include the prefix. An empty prefix iterates the whole store.
+// The iterator must be closed by the caller.
+//
+// The store charges gas for the iteration as usual, so for exports it should be backed by
+// an infinite gas meter.
+func DumpContractState(store KVStore, prefix []byte) (dbm.Iterator, error) {
+	var start []byte
+	if len(prefix) > 0 {
+		start = prefix
+	}
+	iter := store.Iterator(start, prefixEnd(prefix))
+	if err := iter.Error(); err != nil {
+		iter.Close()
+		return nil, err
+	}
+	return iter, nil
+}
+
+// prefixEnd returns the smallest key that is larger than all keys starting with prefix,
+// or nil if there is none (the prefix is empty or consists of 0xff bytes only)
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
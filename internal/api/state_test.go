@@ -0,0 +1,52 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	require.Nil(t, prefixEnd(nil))
+	require.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+	require.Equal(t, []byte("fop"), prefixEnd([]byte("foo")))
+	require.Equal(t, []byte{0x01, 0x03}, prefixEnd([]byte{0x01, 0x02, 0xff}))
+}
+
+func TestDumpContractState(t *testing.T) {
+	gasMeter := NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := NewLookup(gasMeter)
+	// written out of order, including keys just outside of the prefix
+	writes := [][2]string{
+		{"foo\x02", "c"},
+		{"fon", "outside"},
+		{"foo", "a"},
+		{"fop", "outside"},
+		{"foo\x01\xff", "b"},
+		{"foo\xff", "d"},
+	}
+	for _, w := range writes {
+		store.Set([]byte(w[0]), []byte(w[1]))
+	}
+	store.Delete([]byte("foo\x02"))
+
+	dump := func(prefix []byte) [][2]string {
+		iter, err := DumpContractState(store, prefix)
+		require.NoError(t, err)
+		defer iter.Close()
+		entries := [][2]string{}
+		for ; iter.Valid(); iter.Next() {
+			entries = append(entries, [2]string{string(iter.Key()), string(iter.Value())})
+		}
+		require.NoError(t, iter.Error())
+		return entries
+	}
+
+	require.Equal(t, [][2]string{
+		{"foo", "a"},
+		{"foo\x01\xff", "b"},
+		{"foo\xff", "d"},
+	}, dump([]byte("foo")))
+	require.Equal(t, [][2]string{}, dump([]byte("bar")))
+	require.Len(t, dump(nil), 5)
+}
@@ -5,6 +5,8 @@ package api
 */
 import "C"
 
+import "runtime"
+
 func LibwasmvmVersion() (string, error) {
 	version_ptr, err := C.version_str()
 	if err != nil {
@@ -15,3 +17,21 @@ func LibwasmvmVersion() (string, error) {
 	version_copy := C.GoString(version_ptr)
 	return version_copy, nil
 }
+
+// LibwasmvmTarget returns the Rust target triple of the library the Go code links against,
+// which is determined by the build tags. It is empty for a system library (sys_wasmvm build tag).
+func LibwasmvmTarget() string {
+	return libwasmvmTarget
+}
+
+// rustArch returns the Rust name of the architecture the Go code is compiled for
+func rustArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
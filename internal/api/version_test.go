@@ -2,6 +2,7 @@ package api
 
 import (
 	"regexp"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -12,3 +13,12 @@ func TestLibwasmvmVersion(t *testing.T) {
 	require.NoError(t, err)
 	require.Regexp(t, regexp.MustCompile("^([0-9]+)\\.([0-9]+)\\.([0-9]+)(-[a-z0-9.\\-]+)?$"), version)
 }
+
+func TestLibwasmvmTarget(t *testing.T) {
+	target := LibwasmvmTarget()
+	if target == "" {
+		t.Skip("target of system library is unknown")
+	}
+	require.Regexp(t, regexp.MustCompile("^"+rustArch()+"-"), target)
+	require.Contains(t, target, runtime.GOOS)
+}
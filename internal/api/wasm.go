@@ -0,0 +1,760 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Finschia/wasmvm/types"
+)
+
+// This file contains a minimal Wasm binary parser used for static analysis on the Go side.
+// It only decodes the parts of a module we need and is not a validator. The code passed
+// in is expected to have been validated by cosmwasm-vm already (i.e. stored via Create).
+//
+// See https://webassembly.github.io/spec/core/binary/index.html for the format.
+
+const (
+	wasmSectionCustom   = 0
+	wasmSectionImport   = 2
+	wasmSectionFunction = 3
+	wasmSectionMemory   = 5
+	wasmSectionExport   = 7
+	wasmSectionCode     = 10
+
+	wasmExternFunc   = 0x00
+	wasmExternTable  = 0x01
+	wasmExternMemory = 0x02
+	wasmExternGlobal = 0x03
+)
+
+var errUnexpectedEnd = errors.New("unexpected end of Wasm data")
+
+type wasmImport struct {
+	Module string
+	Name   string
+	Kind   byte
+}
+
+type wasmExport struct {
+	Name  string
+	Kind  byte
+	Index uint32
+}
+
+type wasmLimits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+type wasmCustomSection struct {
+	Name string
+	Data []byte
+}
+
+// wasmModule is the subset of a Wasm module used for static analysis
+type wasmModule struct {
+	Imports        []wasmImport
+	NumFuncImports uint32
+	Exports        []wasmExport
+	Memories       []wasmLimits
+	CustomSections []wasmCustomSection
+	// Bodies contains the raw body of each function defined in the module (excluding imports)
+	Bodies [][]byte
+}
+
+// wasmReader is a cursor over Wasm binary data
+type wasmReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *wasmReader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *wasmReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errUnexpectedEnd
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *wasmReader) readBytes(n uint32) ([]byte, error) {
+	if uint64(r.pos)+uint64(n) > uint64(len(r.data)) {
+		return nil, errUnexpectedEnd
+	}
+	out := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return out, nil
+}
+
+// readU32 reads an unsigned LEB128 encoded 32 bit integer
+func (r *wasmReader) readU32() (uint32, error) {
+	var result uint32
+	var shift uint
+	for i := 0; i < 5; i++ {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+		shift += 7
+	}
+	return 0, errors.New("invalid LEB128 encoded u32")
+}
+
+// skipLEB skips a signed or unsigned LEB128 encoded integer of at most maxBytes bytes
+func (r *wasmReader) skipLEB(maxBytes int) error {
+	for i := 0; i < maxBytes; i++ {
+		b, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		if b&0x80 == 0 {
+			return nil
+		}
+	}
+	return errors.New("invalid LEB128 encoding")
+}
+
+func (r *wasmReader) readName() (string, error) {
+	n, err := r.readU32()
+	if err != nil {
+		return "", err
+	}
+	bz, err := r.readBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return string(bz), nil
+}
+
+func (r *wasmReader) readLimits() (wasmLimits, error) {
+	flag, err := r.readByte()
+	if err != nil {
+		return wasmLimits{}, err
+	}
+	min, err := r.readU32()
+	if err != nil {
+		return wasmLimits{}, err
+	}
+	limits := wasmLimits{Min: min}
+	if flag&0x01 != 0 {
+		limits.Max, err = r.readU32()
+		if err != nil {
+			return wasmLimits{}, err
+		}
+		limits.HasMax = true
+	}
+	return limits, nil
+}
+
+// parseWasm parses the sections of the given Wasm blob needed for static analysis
+func parseWasm(code []byte) (*wasmModule, error) {
+	if len(code) < 8 || string(code[0:4]) != "\x00asm" {
+		return nil, errors.New("not a Wasm module")
+	}
+	r := &wasmReader{data: code, pos: 8}
+	module := wasmModule{}
+	for !r.done() {
+		id, err := r.readByte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.readU32()
+		if err != nil {
+			return nil, err
+		}
+		payload, err := r.readBytes(size)
+		if err != nil {
+			return nil, err
+		}
+		section := &wasmReader{data: payload}
+		switch id {
+		case wasmSectionCustom:
+			name, err := section.readName()
+			if err != nil {
+				return nil, err
+			}
+			module.CustomSections = append(module.CustomSections, wasmCustomSection{Name: name, Data: payload[section.pos:]})
+		case wasmSectionImport:
+			err = parseImportSection(section, &module)
+		case wasmSectionMemory:
+			err = parseMemorySection(section, &module)
+		case wasmSectionExport:
+			err = parseExportSection(section, &module)
+		case wasmSectionCode:
+			err = parseCodeSection(section, &module)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing section %d: %w", id, err)
+		}
+	}
+	return &module, nil
+}
+
+func parseImportSection(r *wasmReader, module *wasmModule) error {
+	count, err := r.readU32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		imp := wasmImport{}
+		if imp.Module, err = r.readName(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.readName(); err != nil {
+			return err
+		}
+		if imp.Kind, err = r.readByte(); err != nil {
+			return err
+		}
+		switch imp.Kind {
+		case wasmExternFunc:
+			// type index
+			_, err = r.readU32()
+			module.NumFuncImports++
+		case wasmExternTable:
+			// reftype followed by limits
+			if _, err = r.readByte(); err == nil {
+				_, err = r.readLimits()
+			}
+		case wasmExternMemory:
+			var limits wasmLimits
+			limits, err = r.readLimits()
+			module.Memories = append(module.Memories, limits)
+		case wasmExternGlobal:
+			// valtype followed by mutability
+			_, err = r.readBytes(2)
+		default:
+			return fmt.Errorf("unknown import kind %d", imp.Kind)
+		}
+		if err != nil {
+			return err
+		}
+		module.Imports = append(module.Imports, imp)
+	}
+	return nil
+}
+
+func parseMemorySection(r *wasmReader, module *wasmModule) error {
+	count, err := r.readU32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		limits, err := r.readLimits()
+		if err != nil {
+			return err
+		}
+		module.Memories = append(module.Memories, limits)
+	}
+	return nil
+}
+
+func parseExportSection(r *wasmReader, module *wasmModule) error {
+	count, err := r.readU32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		exp := wasmExport{}
+		if exp.Name, err = r.readName(); err != nil {
+			return err
+		}
+		if exp.Kind, err = r.readByte(); err != nil {
+			return err
+		}
+		if exp.Index, err = r.readU32(); err != nil {
+			return err
+		}
+		module.Exports = append(module.Exports, exp)
+	}
+	return nil
+}
+
+func parseCodeSection(r *wasmReader, module *wasmModule) error {
+	count, err := r.readU32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < count; i++ {
+		size, err := r.readU32()
+		if err != nil {
+			return err
+		}
+		body, err := r.readBytes(size)
+		if err != nil {
+			return err
+		}
+		module.Bodies = append(module.Bodies, body)
+	}
+	return nil
+}
+
+// CheckMemoryPages ensures the memories declared by the given Wasm code do not exceed the given limits
+// in pages. A limit of 0 means no limit. The limits are not enforced at runtime, so a memory without
+// declared maximum could grow beyond maxPages and is rejected if maxPages is set.
+func CheckMemoryPages(code []byte, initialPages uint32, maxPages uint32) error {
+	module, err := parseWasm(code)
+	if err != nil {
+		return err
+	}
+	for _, memory := range module.Memories {
+		if initialPages != 0 && memory.Min > initialPages {
+			return fmt.Errorf("initial memory of %d pages exceeds the limit of %d pages", memory.Min, initialPages)
+		}
+		if maxPages != 0 && memory.Min > maxPages {
+			return fmt.Errorf("initial memory of %d pages exceeds the maximum of %d pages", memory.Min, maxPages)
+		}
+		if maxPages != 0 && !memory.HasMax {
+			return fmt.Errorf("memory without declared maximum exceeds the limit of %d pages", maxPages)
+		}
+		if maxPages != 0 && memory.Max > maxPages {
+			return fmt.Errorf("maximum memory of %d pages exceeds the limit of %d pages", memory.Max, maxPages)
+		}
+	}
+	return nil
+}
+
+// ListEntryPoints returns the names of all exported functions of the given Wasm code in alphabetical
+// order, excluding the exports used by the VM itself (allocate, deallocate, interface_version_* and requires_*)
+func ListEntryPoints(code []byte) ([]string, error) {
+	module, err := parseWasm(code)
+	if err != nil {
+		return nil, err
+	}
+	return module.entryPoints(), nil
+}
+
+// entryPoints returns the exported function names as described in ListEntryPoints
+func (m *wasmModule) entryPoints() []string {
+	entryPoints := []string{}
+	for _, export := range m.Exports {
+		if export.Kind != wasmExternFunc {
+			continue
+		}
+		switch {
+		case export.Name == "allocate" || export.Name == "deallocate":
+		case strings.HasPrefix(export.Name, "interface_version_"):
+		case strings.HasPrefix(export.Name, "requires_"):
+		default:
+			entryPoints = append(entryPoints, export.Name)
+		}
+	}
+	sort.Strings(entryPoints)
+	return entryPoints
+}
+
+// RequiredCapabilities returns the capabilities required by the Wasm code in alphabetical order,
+// i.e. the names of all requires_* function exports without the prefix
+func RequiredCapabilities(code []byte) ([]string, error) {
+	module, err := parseWasm(code)
+	if err != nil {
+		return nil, err
+	}
+	capabilities := []string{}
+	for _, export := range module.Exports {
+		if export.Kind == wasmExternFunc && strings.HasPrefix(export.Name, "requires_") {
+			capabilities = append(capabilities, strings.TrimPrefix(export.Name, "requires_"))
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities, nil
+}
+
+// InterfaceVersion returns the interface version marker exported by the Wasm code,
+// e.g. "interface_version_8". It returns types.ErrMissingInterfaceVersion if there is none.
+func InterfaceVersion(code []byte) (string, error) {
+	module, err := parseWasm(code)
+	if err != nil {
+		return "", err
+	}
+	for _, export := range module.Exports {
+		if export.Kind == wasmExternFunc && strings.HasPrefix(export.Name, "interface_version_") {
+			return export.Name, nil
+		}
+	}
+	return "", types.ErrMissingInterfaceVersion
+}
+
+const (
+	// warnMemoryPages is the initial memory size (in Wasm pages of 64 KiB) above which codeWarnings warns
+	warnMemoryPages = 256
+	// warnEntryPoints is the number of entry points above which codeWarnings warns
+	warnEntryPoints = 50
+)
+
+// deprecatedImports maps imports of old cosmwasm-std versions to their replacements
+var deprecatedImports = map[string]string{
+	"env.canonicalize_address": "env.addr_canonicalize",
+	"env.humanize_address":     "env.addr_humanize",
+}
+
+// codeWarnings returns non-fatal findings for review of the code, like a large memory,
+// many entry points or deprecated imports
+func codeWarnings(module *wasmModule) []string {
+	warnings := []string{}
+	for _, memory := range module.Memories {
+		if memory.Min > warnMemoryPages {
+			warnings = append(warnings, fmt.Sprintf("large initial memory of %d pages (more than %d)", memory.Min, warnMemoryPages))
+		}
+	}
+	if entryPoints := module.entryPoints(); len(entryPoints) > warnEntryPoints {
+		warnings = append(warnings, fmt.Sprintf("many entry points: %d (more than %d)", len(entryPoints), warnEntryPoints))
+	}
+	for _, imp := range module.Imports {
+		name := imp.Module + "." + imp.Name
+		if replacement, ok := deprecatedImports[name]; ok {
+			warnings = append(warnings, fmt.Sprintf("deprecated import %s, use %s", name, replacement))
+		}
+	}
+	return warnings
+}
+
+// MigrateInfo contains the migrate related information of a Wasm module
+type MigrateInfo struct {
+	HasMigrateEntryPoint bool
+	// Version is the migrate version declared in the "cw_migrate_version" custom section, if any
+	Version *uint64
+}
+
+// AnalyzeMigrate extracts the migrate related information from the given Wasm code
+func AnalyzeMigrate(code []byte) (MigrateInfo, error) {
+	module, err := parseWasm(code)
+	if err != nil {
+		return MigrateInfo{}, err
+	}
+	info := MigrateInfo{}
+	for _, export := range module.Exports {
+		if export.Kind == wasmExternFunc && export.Name == "migrate" {
+			info.HasMigrateEntryPoint = true
+		}
+	}
+	for _, section := range module.CustomSections {
+		if section.Name != "cw_migrate_version" {
+			continue
+		}
+		version, err := strconv.ParseUint(string(section.Data), 10, 64)
+		if err != nil {
+			return MigrateInfo{}, fmt.Errorf("invalid migrate version %q: %w", string(section.Data), err)
+		}
+		info.Version = &version
+	}
+	return info, nil
+}
+
+// ExecuteVariants returns the names of the execute message variants the contract accepts, as declared by
+// the JSON schema of its ExecuteMsg in the "cw_execute_schema" custom section.
+//
+// This section is a convention of this package, not something the CosmWasm toolchain emits: contracts
+// built with cosmwasm-std and cosmwasm-schema do not contain it, so a chain that wants to use it must
+// inject the schema (e.g. the one written by cosmwasm-schema) into the code before storing it.
+// Variants with data are expected as objects with a single required property, unit variants as
+// string enums. types.ErrNoVariantInfo is returned if the code has no such section.
+func ExecuteVariants(code []byte) ([]string, error) {
+	module, err := parseWasm(code)
+	if err != nil {
+		return nil, err
+	}
+	for _, section := range module.CustomSections {
+		if section.Name != "cw_execute_schema" {
+			continue
+		}
+		var schema struct {
+			OneOf []struct {
+				Required []string `json:"required"`
+				Enum     []string `json:"enum"`
+			} `json:"oneOf"`
+		}
+		if err := json.Unmarshal(section.Data, &schema); err != nil {
+			return nil, fmt.Errorf("invalid execute schema: %w", err)
+		}
+		variants := []string{}
+		for _, variant := range schema.OneOf {
+			variants = append(variants, variant.Enum...)
+			if len(variant.Required) == 1 {
+				variants = append(variants, variant.Required[0])
+			}
+		}
+		return variants, nil
+	}
+	return nil, types.ErrNoVariantInfo
+}
+
+// wasmInstruction is a decoded instruction of a function body. Only the immediates
+// needed for the analysis are kept.
+type wasmInstruction struct {
+	Opcode byte
+	// Labels contains the relative branch depths of br, br_if and br_table
+	Labels []uint32
+	// FuncIndex contains the callee of call
+	FuncIndex uint32
+}
+
+// walkInstructions decodes the given function body and calls fn for each instruction
+func walkInstructions(body []byte, fn func(inst wasmInstruction)) error {
+	r := &wasmReader{data: body}
+	// skip local declarations
+	groups, err := r.readU32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < groups; i++ {
+		if _, err := r.readU32(); err != nil {
+			return err
+		}
+		if _, err := r.readByte(); err != nil {
+			return err
+		}
+	}
+
+	for !r.done() {
+		op, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		inst := wasmInstruction{Opcode: op}
+		switch {
+		case op == 0x02 || op == 0x03 || op == 0x04: // block, loop, if
+			// block type is either 0x40, a value type or a s33 type index
+			err = r.skipLEB(5)
+		case op == 0x0C || op == 0x0D: // br, br_if
+			var label uint32
+			label, err = r.readU32()
+			inst.Labels = []uint32{label}
+		case op == 0x0E: // br_table
+			var count uint32
+			count, err = r.readU32()
+			for i := uint32(0); err == nil && i <= count; i++ {
+				var label uint32
+				label, err = r.readU32()
+				inst.Labels = append(inst.Labels, label)
+			}
+		case op == 0x10: // call
+			inst.FuncIndex, err = r.readU32()
+		case op == 0x11: // call_indirect
+			if _, err = r.readU32(); err == nil {
+				_, err = r.readU32()
+			}
+		case op == 0x1C: // select with types
+			var count uint32
+			count, err = r.readU32()
+			if err == nil {
+				_, err = r.readBytes(count)
+			}
+		case op >= 0x20 && op <= 0x26: // local.*, global.*, table.get, table.set
+			_, err = r.readU32()
+		case op >= 0x28 && op <= 0x3E: // loads and stores with memarg
+			if _, err = r.readU32(); err == nil {
+				_, err = r.readU32()
+			}
+		case op == 0x3F || op == 0x40: // memory.size, memory.grow
+			_, err = r.readByte()
+		case op == 0x41: // i32.const
+			err = r.skipLEB(5)
+		case op == 0x42: // i64.const
+			err = r.skipLEB(10)
+		case op == 0x43: // f32.const
+			_, err = r.readBytes(4)
+		case op == 0x44: // f64.const
+			_, err = r.readBytes(8)
+		case op == 0xD0: // ref.null
+			_, err = r.readByte()
+		case op == 0xD2: // ref.func
+			_, err = r.readU32()
+		case op == 0xFC:
+			err = skipPrefixedInstruction(r)
+		case op <= 0x01 || op == 0x05 || op == 0x0B || op == 0x0F || op == 0x1A || op == 0x1B || (op >= 0x45 && op <= 0xC4) || op == 0xD1:
+			// no immediates
+		default:
+			return fmt.Errorf("unsupported opcode 0x%02x", op)
+		}
+		if err != nil {
+			return err
+		}
+		fn(inst)
+	}
+	return nil
+}
+
+// skipPrefixedInstruction skips the immediates of an instruction with the 0xFC prefix
+func skipPrefixedInstruction(r *wasmReader) error {
+	sub, err := r.readU32()
+	if err != nil {
+		return err
+	}
+	var immediates uint32
+	switch {
+	case sub <= 7: // saturating truncations
+		immediates = 0
+	case sub == 8: // memory.init
+		if _, err := r.readU32(); err != nil {
+			return err
+		}
+		_, err = r.readByte()
+		return err
+	case sub == 10: // memory.copy
+		_, err = r.readBytes(2)
+		return err
+	case sub == 11: // memory.fill
+		_, err = r.readByte()
+		return err
+	case sub == 9 || sub == 13 || (sub >= 15 && sub <= 17): // data.drop, elem.drop, table.grow, table.size, table.fill
+		immediates = 1
+	case sub == 12 || sub == 14: // table.init, table.copy
+		immediates = 2
+	default:
+		return fmt.Errorf("unsupported opcode 0xfc %d", sub)
+	}
+	for i := uint32(0); i < immediates; i++ {
+		if _, err := r.readU32(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAbortStub returns true if the function body ends with `loop br 0 end unreachable` and contains no
+// other control flow or calls before, which is the abort handler rustc emits for the standard library
+func isAbortStub(body []byte) (bool, error) {
+	var opcodes []byte
+	var labels [][]uint32
+	err := walkInstructions(body, func(inst wasmInstruction) {
+		opcodes = append(opcodes, inst.Opcode)
+		labels = append(labels, inst.Labels)
+	})
+	if err != nil {
+		return false, err
+	}
+	tail := []byte{0x03, 0x0C, 0x0B, 0x00, 0x0B} // loop br end unreachable end
+	if len(opcodes) < len(tail) || !bytes.Equal(opcodes[len(opcodes)-len(tail):], tail) {
+		return false, nil
+	}
+	if labels[len(opcodes)-4][0] != 0 {
+		return false, nil
+	}
+	for _, op := range opcodes[:len(opcodes)-len(tail)] {
+		switch op {
+		case 0x02, 0x03, 0x04, 0x05, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F, 0x10, 0x11:
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// addGoAnalysis adds the results of the static checks done on the Go side to the report. They are
+// best-effort since the parser here does not support everything libwasmvm accepts: if the module
+// cannot be parsed, the fields are left empty, and if a function body cannot be decoded,
+// HasUncheckedLoops is left false.
+func addGoAnalysis(report *types.AnalysisReport, wasm []byte) {
+	module, err := parseWasm(wasm)
+	if err != nil {
+		return
+	}
+	report.EntryPoints = module.entryPoints()
+	report.Warnings = codeWarnings(module)
+	if unchecked, err := hasUncheckedLoops(module); err == nil {
+		report.HasUncheckedLoops = unchecked
+	}
+}
+
+// hasUncheckedLoops checks if any function contains a loop that can never be left, i.e. a loop
+// without a branch out of it, a return, a trap or a fallthrough at its end, which also does
+// not call into an imported function. Calls to imports are the points where the host gets control
+// and charges gas for the operation, so such loops only stop when the instruction metering runs
+// out of gas.
+//
+// Functions without other control flow that end in an endless `loop {}` are ignored (see isAbortStub).
+// This is the abort handler of the Rust standard library, such that every Rust contract would be flagged
+// otherwise. It can only be reached after a panic, which aborts the contract before. A `loop {}` in
+// contract code is still detected unless it was compiled into a function of its own.
+//
+// This is a heuristic for informational purposes. Branch conditions are not evaluated, so a loop
+// that can only be left via an exit that is never taken is not detected.
+func hasUncheckedLoops(module *wasmModule) (bool, error) {
+	type frame struct {
+		isLoop    bool
+		exits     bool
+		callsHost bool
+	}
+	for _, body := range module.Bodies {
+		stub, err := isAbortStub(body)
+		if err != nil {
+			return false, err
+		}
+		if stub {
+			continue
+		}
+		var stack []frame
+		// exitFrames marks all loops nested deeper than the given stack index as exited
+		exitFrames := func(target int) {
+			for i := target + 1; i < len(stack); i++ {
+				stack[i].exits = true
+			}
+		}
+		reachable := true
+		found := false
+		err = walkInstructions(body, func(inst wasmInstruction) {
+			switch inst.Opcode {
+			case 0x02, 0x04: // block, if
+				stack = append(stack, frame{})
+			case 0x03: // loop
+				stack = append(stack, frame{isLoop: true})
+			case 0x05: // else
+				reachable = true
+			case 0x0B: // end (the last one ends the function body itself)
+				if len(stack) == 0 {
+					return
+				}
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if top.isLoop {
+					if reachable {
+						// falls through at the end
+						top.exits = true
+					}
+					if !top.exits && !top.callsHost {
+						found = true
+					}
+				}
+				// conservatively assume the code after any block is reachable
+				reachable = true
+			case 0x0C, 0x0D, 0x0E: // br, br_if, br_table
+				for _, label := range inst.Labels {
+					exitFrames(len(stack) - 1 - int(label))
+				}
+				if inst.Opcode != 0x0D {
+					reachable = false
+				}
+			case 0x00, 0x0F: // unreachable, return
+				exitFrames(-1)
+				reachable = false
+			case 0x10: // call
+				if inst.FuncIndex < module.NumFuncImports {
+					for i := range stack {
+						stack[i].callsHost = true
+					}
+				}
+			}
+		})
+		if err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/types"
+)
+
+// buildWasm creates a module with a single `env.host` import of type () -> () and one
+// function of the same type for each of the given bodies
+func buildWasm(bodies ...[]byte) []byte {
+	section := func(id byte, payload []byte) []byte {
+		return append([]byte{id, byte(len(payload))}, payload...)
+	}
+	wasm := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	// type section: one type () -> ()
+	wasm = append(wasm, section(1, []byte{0x01, 0x60, 0x00, 0x00})...)
+	// import section: env.host
+	wasm = append(wasm, section(2, []byte{0x01, 0x03, 'e', 'n', 'v', 0x04, 'h', 'o', 's', 't', 0x00, 0x00})...)
+	// function section
+	functions := []byte{byte(len(bodies))}
+	for range bodies {
+		functions = append(functions, 0x00)
+	}
+	wasm = append(wasm, section(3, functions)...)
+	// code section, all functions without locals
+	code := []byte{byte(len(bodies))}
+	for _, body := range bodies {
+		code = append(code, byte(len(body)+1), 0x00)
+		code = append(code, body...)
+	}
+	return append(wasm, section(10, code)...)
+}
+
+// withCustomSection appends a custom section to the given Wasm module
+func withCustomSection(wasm []byte, name string, data []byte) []byte {
+	payload := append([]byte{byte(len(name))}, name...)
+	payload = append(payload, data...)
+	wasm = append(wasm, 0x00, byte(len(payload)))
+	return append(wasm, payload...)
+}
+
+func TestParseWasm(t *testing.T) {
+	wasm, err := ioutil.ReadFile("../../testdata/hackatom.wasm")
+	require.NoError(t, err)
+	module, err := parseWasm(wasm)
+	require.NoError(t, err)
+	require.Equal(t, []wasmLimits{{Min: 17}}, module.Memories)
+	exports := []string{}
+	for _, export := range module.Exports {
+		exports = append(exports, export.Name)
+	}
+	require.Contains(t, exports, "instantiate")
+	require.Contains(t, exports, "interface_version_8")
+
+	_, err = parseWasm([]byte("not wasm"))
+	require.Error(t, err)
+	_, err = parseWasm(buildWasm([]byte{0x0B})[:30])
+	require.Error(t, err)
+}
+
+func TestCheckMemoryPages(t *testing.T) {
+	// hackatom declares 17 pages initially and no maximum
+	wasm, err := ioutil.ReadFile("../../testdata/hackatom.wasm")
+	require.NoError(t, err)
+	require.NoError(t, CheckMemoryPages(wasm, 0, 0))
+	require.NoError(t, CheckMemoryPages(wasm, 17, 0))
+	require.EqualError(t, CheckMemoryPages(wasm, 16, 0), "initial memory of 17 pages exceeds the limit of 16 pages")
+	require.EqualError(t, CheckMemoryPages(wasm, 0, 16), "initial memory of 17 pages exceeds the maximum of 16 pages")
+	require.EqualError(t, CheckMemoryPages(wasm, 0, 32), "memory without declared maximum exceeds the limit of 32 pages")
+}
+
+func TestHasUncheckedLoops(t *testing.T) {
+	cases := map[string]struct {
+		body     []byte
+		expected bool
+	}{
+		"no loop": {
+			body:     []byte{0x01, 0x0B}, // nop
+			expected: false,
+		},
+		"endless loop": {
+			body:     []byte{0x03, 0x40, 0x0C, 0x00, 0x0B, 0x0B}, // loop br 0 end
+			expected: true,
+		},
+		"endless loop calling host": {
+			body:     []byte{0x03, 0x40, 0x10, 0x00, 0x0C, 0x00, 0x0B, 0x0B}, // loop call 0 br 0 end
+			expected: false,
+		},
+		"endless loop calling local function": {
+			body:     []byte{0x03, 0x40, 0x10, 0x01, 0x0C, 0x00, 0x0B, 0x0B}, // loop call 1 br 0 end
+			expected: true,
+		},
+		"loop with conditional back edge": {
+			body:     []byte{0x03, 0x40, 0x41, 0x01, 0x0D, 0x00, 0x0B, 0x0B}, // loop i32.const 1 br_if 0 end
+			expected: false,
+		},
+		"loop with exit": {
+			// block loop i32.const 1 br_if 1 br 0 end end
+			body:     []byte{0x02, 0x40, 0x03, 0x40, 0x41, 0x01, 0x0D, 0x01, 0x0C, 0x00, 0x0B, 0x0B, 0x0B},
+			expected: false,
+		},
+		"loop with return": {
+			// loop i32.const 1 if return end br 0 end
+			body:     []byte{0x03, 0x40, 0x41, 0x01, 0x04, 0x40, 0x0F, 0x0B, 0x0C, 0x00, 0x0B, 0x0B},
+			expected: false,
+		},
+		"abort loop": {
+			body:     []byte{0x03, 0x40, 0x0C, 0x00, 0x0B, 0x00, 0x0B}, // loop br 0 end unreachable
+			expected: false,
+		},
+		"abort loop after plain instructions": {
+			// local.get 0 drop loop br 0 end unreachable
+			body:     []byte{0x20, 0x00, 0x1A, 0x03, 0x40, 0x0C, 0x00, 0x0B, 0x00, 0x0B},
+			expected: false,
+		},
+		"endless loop followed by unreachable in a branch": {
+			// i32.const 1 if loop br 0 end unreachable end
+			body:     []byte{0x41, 0x01, 0x04, 0x40, 0x03, 0x40, 0x0C, 0x00, 0x0B, 0x00, 0x0B, 0x0B},
+			expected: true,
+		},
+		"endless loop followed by unreachable after a call": {
+			// call 1 loop br 0 end unreachable
+			body:     []byte{0x10, 0x01, 0x03, 0x40, 0x0C, 0x00, 0x0B, 0x00, 0x0B},
+			expected: true,
+		},
+		"endless loop followed by other code": {
+			body:     []byte{0x03, 0x40, 0x0C, 0x00, 0x0B, 0x01, 0x00, 0x0B}, // loop br 0 end nop unreachable
+			expected: true,
+		},
+		"endless loop with work followed by unreachable": {
+			body:     []byte{0x03, 0x40, 0x01, 0x0C, 0x00, 0x0B, 0x00, 0x0B}, // loop nop br 0 end unreachable
+			expected: true,
+		},
+		"inner loop without exit": {
+			// block loop loop br 0 end i32.const 1 br_if 1 end end
+			body:     []byte{0x02, 0x40, 0x03, 0x40, 0x03, 0x40, 0x0C, 0x00, 0x0B, 0x41, 0x01, 0x0D, 0x01, 0x0B, 0x0B, 0x0B},
+			expected: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			module, err := parseWasm(buildWasm(tc.body))
+			require.NoError(t, err)
+			res, err := hasUncheckedLoops(module)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res)
+		})
+	}
+
+	// The endless loop in the abort handler of the Rust standard library is ignored
+	wasm, err := ioutil.ReadFile("../../testdata/reflect.wasm")
+	require.NoError(t, err)
+	module, err := parseWasm(wasm)
+	require.NoError(t, err)
+	res, err := hasUncheckedLoops(module)
+	require.NoError(t, err)
+	require.False(t, res)
+
+	// but not the cpu_loop execute message of hackatom
+	wasm, err = ioutil.ReadFile("../../testdata/hackatom.wasm")
+	require.NoError(t, err)
+	module, err = parseWasm(wasm)
+	require.NoError(t, err)
+	res, err = hasUncheckedLoops(module)
+	require.NoError(t, err)
+	require.True(t, res)
+}
+
+func TestAddGoAnalysis(t *testing.T) {
+	var report types.AnalysisReport
+	addGoAnalysis(&report, buildWasm([]byte{0x03, 0x40, 0x0C, 0x00, 0x0B, 0x0B}))
+	require.True(t, report.HasUncheckedLoops)
+
+	// bodies with instructions unknown to the parser leave the flag false
+	report = types.AnalysisReport{}
+	addGoAnalysis(&report, buildWasm([]byte{0xFD, 0x0C, 0x0B}, []byte{0x03, 0x40, 0x0C, 0x00, 0x0B, 0x0B}))
+	require.False(t, report.HasUncheckedLoops)
+	require.Equal(t, []string{}, report.Warnings)
+
+	// unparsable modules leave all fields empty
+	report = types.AnalysisReport{HasIBCEntryPoints: true}
+	addGoAnalysis(&report, []byte("not wasm"))
+	require.Equal(t, types.AnalysisReport{HasIBCEntryPoints: true}, report)
+}
+
+func TestAnalyzeMigrate(t *testing.T) {
+	wasm, err := ioutil.ReadFile("../../testdata/hackatom.wasm")
+	require.NoError(t, err)
+	info, err := AnalyzeMigrate(wasm)
+	require.NoError(t, err)
+	require.Equal(t, MigrateInfo{HasMigrateEntryPoint: true}, info)
+
+	wasm = withCustomSection(buildWasm([]byte{0x0B}), "cw_migrate_version", []byte("42"))
+	info, err = AnalyzeMigrate(wasm)
+	require.NoError(t, err)
+	require.False(t, info.HasMigrateEntryPoint)
+	require.Equal(t, uint64(42), *info.Version)
+
+	wasm = withCustomSection(buildWasm([]byte{0x0B}), "cw_migrate_version", []byte("v1"))
+	_, err = AnalyzeMigrate(wasm)
+	require.ErrorContains(t, err, "invalid migrate version")
+}
+
+func TestInterfaceVersion(t *testing.T) {
+	version, err := InterfaceVersion(buildWasm())
+	require.ErrorIs(t, err, types.ErrMissingInterfaceVersion)
+	require.Equal(t, "", version)
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+	capabilities, err := RequiredCapabilities(buildWasm())
+	require.NoError(t, err)
+	require.Equal(t, []string{}, capabilities)
+
+	wasm, err := ioutil.ReadFile("../../testdata/ibc_reflect.wasm")
+	require.NoError(t, err)
+	capabilities, err = RequiredCapabilities(wasm)
+	require.NoError(t, err)
+	require.Equal(t, []string{"iterator", "stargate"}, capabilities)
+}
+
+func TestCodeWarnings(t *testing.T) {
+	module, err := parseWasm(buildWasm())
+	require.NoError(t, err)
+	require.Equal(t, []string{}, codeWarnings(module))
+
+	module.Memories = []wasmLimits{{Min: 257}}
+	module.Imports = append(module.Imports, wasmImport{Module: "env", Name: "canonicalize_address", Kind: wasmExternFunc})
+	for i := 0; i < 51; i++ {
+		module.Exports = append(module.Exports, wasmExport{Name: fmt.Sprintf("entry_%d", i), Kind: wasmExternFunc})
+	}
+	require.Equal(t, []string{
+		"large initial memory of 257 pages (more than 256)",
+		"many entry points: 51 (more than 50)",
+		"deprecated import env.canonicalize_address, use env.addr_canonicalize",
+	}, codeWarnings(module))
+
+	// hackatom has no warnings
+	wasm, err := ioutil.ReadFile("../../testdata/hackatom.wasm")
+	require.NoError(t, err)
+	module, err = parseWasm(wasm)
+	require.NoError(t, err)
+	require.Equal(t, []string{}, codeWarnings(module))
+}
+
+func TestExecuteVariants(t *testing.T) {
+	_, err := ExecuteVariants(buildWasm())
+	require.ErrorIs(t, err, types.ErrNoVariantInfo)
+
+	schema := `{"oneOf":[{"type":"object","required":["release"]},{"type":"string","enum":["noop","reset"]}]}`
+	variants, err := ExecuteVariants(withCustomSection(buildWasm(), "cw_execute_schema", []byte(schema)))
+	require.NoError(t, err)
+	require.Equal(t, []string{"release", "noop", "reset"}, variants)
+
+	_, err = ExecuteVariants(withCustomSection(buildWasm(), "cw_execute_schema", []byte("{")))
+	require.ErrorContains(t, err, "invalid execute schema")
+}
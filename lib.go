@@ -1,8 +1,22 @@
 package cosmwasm
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Finschia/wasmvm/internal/api"
 	"github.com/Finschia/wasmvm/types"
@@ -14,6 +28,39 @@ type Checksum []byte
 // WasmCode is an alias for raw bytes of the wasm compiled code
 type WasmCode []byte
 
+// CreateChecksum returns the checksum of the given code without storing it. This is the sha256 hash of
+// the code, exactly as computed by Create, but without the validation of the code.
+func CreateChecksum(code WasmCode) Checksum {
+	hash := sha256.Sum256(code)
+	return hash[:]
+}
+
+// VerifyChecksum returns an error wrapping types.ErrChecksumMismatch if code does not hash to expected.
+// This allows validating code from external sources before storing it.
+func VerifyChecksum(code WasmCode, expected Checksum) error {
+	if actual := CreateChecksum(code); !bytes.Equal(actual, expected) {
+		return fmt.Errorf("%w: expected %X, got %X", types.ErrChecksumMismatch, []byte(expected), []byte(actual))
+	}
+	return nil
+}
+
+// Instantiate2Address returns the canonical address of a contract instantiated with the given code checksum,
+// creator, salt and (optional) init message, using the derivation of cosmwasm-std's instantiate2_address.
+// The address does not depend on the chain state, so it can be predicted before the contract exists.
+// Pass a nil initMsg to leave it out of the derivation, like wasmd does by default.
+func Instantiate2Address(checksum Checksum, creator types.CanonicalAddress, salt []byte, initMsg []byte) types.CanonicalAddress {
+	key := []byte("wasm\x00")
+	for _, part := range [][]byte{checksum, creator, salt, initMsg} {
+		key = binary.BigEndian.AppendUint64(key, uint64(len(part)))
+		key = append(key, part...)
+	}
+	typeHash := sha256.Sum256([]byte("module"))
+	hasher := sha256.New()
+	hasher.Write(typeHash[:])
+	hasher.Write(key)
+	return hasher.Sum(nil)
+}
+
 // KVStore is a reference to some sub-kvstore that is valid for one instance of a code
 type KVStore = api.KVStore
 
@@ -26,14 +73,74 @@ type Querier = types.Querier
 // GasMeter is a read-only version of the sdk gas meter
 type GasMeter = api.GasMeter
 
+// RemainingGas returns the gas left in gasMeter, e.g. to budget sub-queries in a Querier.
+// See api.RemainingGas for how gas meters without a limit are handled.
+func RemainingGas(gasMeter GasMeter) uint64 {
+	return api.RemainingGas(gasMeter)
+}
+
 // VM is the main entry point to this library.
 // You should create an instance with its own subdirectory to manage state inside,
 // and call it for all cosmwasm code related actions.
 type VM struct {
-	cache      api.Cache
-	printDebug bool
+	// config is the configuration the VM was created with (see coldStartConfig)
+	config             types.VMConfig
+	cache              api.Cache
+	dataDir            string
+	cacheSize          uint32
+	memoryLimit        uint32
+	printDebug         bool
+	entryPointGas      types.EntryPointGas
+	iteratorGasCosts   types.IteratorGasCosts
+	initialMemoryPages uint32
+	maxMemoryPages     uint32
+	// clock is the time source for all timing related logic of the VM
+	clock func() time.Time
+	// pinned contains the checksums pinned via this VM instance (as strings to be usable as map keys)
+	pinned      map[string]struct{}
+	pinnedMutex sync.Mutex
+	echoEnv     bool
+	strictJSON  bool
+	// maxEvents and maxAttributes limit the size of responses. 0 means unlimited.
+	maxEvents     uint32
+	maxAttributes uint32
+	// uniqueEventTypes rejects responses with two events of the same type
+	uniqueEventTypes bool
+	captureInputs    bool
+	trackQueryReads  bool
+	strictSubMsgs    bool
+	replyErrorCodes  bool
+	canonicalEvents  bool
+	// cacheSoftLimitRatio and onCacheSoftLimit configure the soft cache size warning (see checkCacheSoftLimit)
+	cacheSoftLimitRatio float64
+	onCacheSoftLimit    func(used, capacity uint64)
+	cacheSoftLimitMutex sync.Mutex
+	cacheAboveSoftLimit bool
+	// codeCache caches the results of GetCode. nil if disabled.
+	codeCache *codeCache
+	// codeChunkSize is the buffer size of GetCodeTo
+	codeChunkSize int
+	// replyHandlers caches if the code of a checksum has a reply entry point (see checkReplyHandler)
+	replyHandlers sync.Map
+	// activeCalls is the number of contract calls in progress (see ActiveCallCount)
+	activeCalls atomic.Int64
+	// defaultGasLimit is used for contract calls with a gas limit of 0 (see SetDefaultGasLimit)
+	defaultGasLimit atomic.Uint64
+	// supportedFeatures are the capabilities enabled for all contracts
+	supportedFeatures []string
+	// capabilityGrants contains the additional capabilities per checksum (see GrantCapability)
+	capabilityGrants map[string][]string
+	grantsMutex      sync.Mutex
+	// auditSink receives a record of every contract call (see VMConfig.AuditSink). nil if disabled.
+	auditSink func(entry types.AuditEntry)
 }
 
+// wasmPagesPerMiB is the number of Wasm pages (64 KiB each) in one MiB
+const wasmPagesPerMiB = 16
+
+// mib is the number of bytes in one MiB
+const mib = 1024 * 1024
+
 // NewVM creates a new VM.
 //
 // `dataDir` is a base directory for Wasm blobs and various caches.
@@ -43,11 +150,130 @@ type VM struct {
 // `cacheSize` sets the size in MiB of an in-memory cache for e.g. module caching. Set to 0 to disable.
 // `deserCost` sets the gas cost of deserializing one byte of data.
 func NewVM(dataDir string, supportedFeatures string, memoryLimit uint32, printDebug bool, cacheSize uint32) (*VM, error) {
-	cache, err := api.InitCache(dataDir, supportedFeatures, cacheSize, memoryLimit)
+	return NewVMWithConfig(types.VMConfig{
+		DataDir:           dataDir,
+		SupportedFeatures: supportedFeatures,
+		MemoryLimit:       memoryLimit,
+		PrintDebug:        printDebug,
+		CacheSize:         cacheSize,
+	})
+}
+
+// NewVMWithFeatures works like NewVM but takes the features supported by the chain as a list.
+// An error wrapping types.ErrUnknownCapability is returned for features not in types.KnownCapabilities.
+// Use NewVM to enable chain specific capabilities.
+func NewVMWithFeatures(dataDir string, features []string, memoryLimit uint32, printDebug bool, cacheSize uint32) (*VM, error) {
+	if err := types.ValidateCapabilities(features); err != nil {
+		return nil, err
+	}
+	return NewVMWithConfig(types.VMConfig{
+		DataDir:           dataDir,
+		SupportedFeatures: strings.Join(features, ","),
+		MemoryLimit:       memoryLimit,
+		PrintDebug:        printDebug,
+		CacheSize:         cacheSize,
+	})
+}
+
+// NewVMWithConfig creates a new VM from the given config. This allows setting options
+// that are not available in NewVM.
+func NewVMWithConfig(config types.VMConfig) (*VM, error) {
+	if err := validateMemoryConfig(config); err != nil {
+		return nil, err
+	}
+	cache, err := api.InitCache(config.DataDir, config.SupportedFeatures, config.CacheSize, config.MemoryLimit)
 	if err != nil {
 		return nil, err
 	}
-	return &VM{cache: cache, printDebug: printDebug}, nil
+	cache.SetIteratorGasCosts(config.IteratorGasCosts)
+	codeChunkSize := int(config.CodeChunkSize)
+	if codeChunkSize == 0 {
+		codeChunkSize = defaultCodeChunkSize
+	}
+	var codes *codeCache
+	if config.CodeReadCacheMiB != 0 {
+		codes = newCodeCache(uint64(config.CodeReadCacheMiB) * mib)
+	}
+	return &VM{
+		config:              config,
+		cache:               cache,
+		dataDir:             config.DataDir,
+		cacheSize:           config.CacheSize,
+		memoryLimit:         config.MemoryLimit,
+		printDebug:          config.PrintDebug,
+		entryPointGas:       config.EntryPointGas,
+		iteratorGasCosts:    config.IteratorGasCosts,
+		initialMemoryPages:  config.InitialMemoryPages,
+		maxMemoryPages:      config.MaxMemoryPages,
+		clock:               time.Now,
+		pinned:              make(map[string]struct{}),
+		echoEnv:             config.EchoEnv,
+		strictJSON:          config.StrictJSON,
+		maxEvents:           config.MaxEventsPerResponse,
+		maxAttributes:       config.MaxAttributesPerResponse,
+		uniqueEventTypes:    config.UniqueEventTypes,
+		canonicalEvents:     config.CanonicalEvents,
+		captureInputs:       config.CaptureInputs,
+		trackQueryReads:     config.TrackQueryReads,
+		strictSubMsgs:       config.StrictSubMessages,
+		replyErrorCodes:     config.ReplyErrorCodes,
+		cacheSoftLimitRatio: config.CacheSoftLimitRatio,
+		onCacheSoftLimit:    config.OnCacheSoftLimit,
+		auditSink:           config.AuditSink,
+		codeCache:           codes,
+		codeChunkSize:       codeChunkSize,
+		supportedFeatures:   parseCapabilities(config.SupportedFeatures),
+		capabilityGrants:    make(map[string][]string),
+	}, nil
+}
+
+// SetClock replaces the time source of the VM, which defaults to time.Now.
+// This is meant for tests that need deterministic timing.
+func (vm *VM) SetClock(clock func() time.Time) {
+	vm.clock = clock
+}
+
+// SetDefaultGasLimit sets the gas limit used for contract calls with a gas limit of 0.
+// Without a default (or with a default of 0), such calls run out of gas immediately.
+func (vm *VM) SetDefaultGasLimit(limit uint64) {
+	vm.defaultGasLimit.Store(limit)
+}
+
+// gasLimitOrDefault returns the default gas limit if gasLimit is 0
+func (vm *VM) gasLimitOrDefault(gasLimit uint64) uint64 {
+	if gasLimit == 0 {
+		return vm.defaultGasLimit.Load()
+	}
+	return gasLimit
+}
+
+// deadline is a point in time measured by the clock of a VM
+type deadline struct {
+	clock func() time.Time
+	at    time.Time
+}
+
+// newDeadline creates a deadline `timeout` from now
+func (vm *VM) newDeadline(timeout time.Duration) deadline {
+	return deadline{clock: vm.clock, at: vm.clock().Add(timeout)}
+}
+
+// Exceeded returns true if the deadline has passed
+func (d deadline) Exceeded() bool {
+	return d.clock().After(d.at)
+}
+
+func validateMemoryConfig(config types.VMConfig) error {
+	if uint64(config.MaxMemoryPages) > uint64(config.MemoryLimit)*wasmPagesPerMiB {
+		return fmt.Errorf("%w: max memory pages %d exceed memory limit of %d MiB", types.ErrMemoryConfigInvalid, config.MaxMemoryPages, config.MemoryLimit)
+	}
+	if uint64(config.InitialMemoryPages) > uint64(config.MemoryLimit)*wasmPagesPerMiB {
+		return fmt.Errorf("%w: initial memory pages %d exceed memory limit of %d MiB", types.ErrMemoryConfigInvalid, config.InitialMemoryPages, config.MemoryLimit)
+	}
+	if config.MaxMemoryPages != 0 && config.InitialMemoryPages > config.MaxMemoryPages {
+		return fmt.Errorf("%w: initial memory pages %d exceed max memory pages %d", types.ErrMemoryConfigInvalid, config.InitialMemoryPages, config.MaxMemoryPages)
+	}
+	return nil
 }
 
 // Cleanup should be called when no longer using this to free resources on the rust-side
@@ -66,7 +292,107 @@ func (vm *VM) Cleanup() {
 //
 // TODO: return gas cost? Add gas limit??? there is no metering here...
 func (vm *VM) Create(code WasmCode) (Checksum, error) {
-	return api.Create(vm.cache, code)
+	if vm.initialMemoryPages != 0 || vm.maxMemoryPages != 0 {
+		if err := api.CheckMemoryPages(code, vm.initialMemoryPages, vm.maxMemoryPages); err != nil {
+			return nil, err
+		}
+	}
+	return vm.storeCode(code)
+}
+
+// storeCode stores and compiles the code with the capabilities enabled for it, see GrantCapability
+func (vm *VM) storeCode(code WasmCode) (Checksum, error) {
+	enabled := vm.grantedCapabilities(code)
+	if enabled == nil {
+		return api.Create(vm.cache, code)
+	}
+	// The capabilities of the cache cannot be extended per code, so the code is validated and
+	// compiled in a temporary cache with the granted capabilities first.
+	if err := checkCodeWithCapabilities(code, enabled, vm.memoryLimit); err != nil {
+		return nil, err
+	}
+	return api.StoreCodeUnchecked(vm.cache, code)
+}
+
+// GrantCapability enables an additional capability for the contract code with the given checksum only,
+// augmenting the capabilities enabled for all contracts in VMConfig.SupportedFeatures.
+// Since capabilities are checked when code is stored, the grant must happen before calling Create.
+func (vm *VM) GrantCapability(checksum Checksum, capability string) {
+	vm.grantsMutex.Lock()
+	defer vm.grantsMutex.Unlock()
+	key := string(checksum)
+	for _, granted := range vm.capabilityGrants[key] {
+		if granted == capability {
+			return
+		}
+	}
+	vm.capabilityGrants[key] = append(vm.capabilityGrants[key], capability)
+}
+
+// grantedCapabilities returns the global capabilities together with the grants for the code if the
+// code needs the grants and they satisfy its requirements. Otherwise it returns nil and the code is
+// stored with the global capabilities only.
+func (vm *VM) grantedCapabilities(code WasmCode) []string {
+	vm.grantsMutex.Lock()
+	grants := vm.capabilityGrants[string(CreateChecksum(code))]
+	vm.grantsMutex.Unlock()
+	if len(grants) == 0 {
+		return nil
+	}
+	required, err := api.RequiredCapabilities(code)
+	if err != nil {
+		// invalid code is rejected by libwasmvm
+		return nil
+	}
+	if _, ok := types.CapabilitiesSatisfied(required, vm.supportedFeatures); ok {
+		return nil
+	}
+	enabled := append(append([]string{}, vm.supportedFeatures...), grants...)
+	if _, ok := types.CapabilitiesSatisfied(required, enabled); !ok {
+		return nil
+	}
+	return enabled
+}
+
+// checkCodeWithCapabilities runs all checks of Create on the code with the given capabilities enabled
+func checkCodeWithCapabilities(code WasmCode, capabilities []string, memoryLimit uint32) error {
+	tmpdir, err := os.MkdirTemp("", "wasmvm-check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+	cache, err := api.InitCache(tmpdir, strings.Join(capabilities, ","), 0, memoryLimit)
+	if err != nil {
+		return err
+	}
+	defer api.ReleaseCache(cache)
+	_, err = api.Create(cache, code)
+	return err
+}
+
+// parseCapabilities splits a comma separated list of capabilities as used in VMConfig.SupportedFeatures
+func parseCapabilities(list string) []string {
+	capabilities := []string{}
+	for _, capability := range strings.Split(list, ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// StoreCodeUnchecked works like Create but skips all validation of the code, including the
+// capability and memory checks. Invalid code is only detected when it is instantiated.
+//
+// This must only be used during deterministic replay of code that was already validated when
+// it was stored originally, e.g. when re-importing the codes of a chain. Code from untrusted
+// sources must always be stored with Create.
+//
+// libwasmvm does not expose this, so the code is written to the cache directory directly. This is only
+// possible for caches created by the libwasmvm version in use, otherwise types.ErrCacheVersionMismatch
+// is returned (see MigrateCache).
+func (vm *VM) StoreCodeUnchecked(code WasmCode) (Checksum, error) {
+	return api.StoreCodeUnchecked(vm.cache, code)
 }
 
 // GetCode will load the original wasm code for the given code id.
@@ -76,15 +402,90 @@ func (vm *VM) Create(code WasmCode) (Checksum, error) {
 // This can be used so that the (short) code id (hash) is stored in the iavl tree
 // and the larger binary blobs (wasm and pre-compiles) are all managed by the
 // rust library
+//
+// If VMConfig.CodeReadCacheMiB is set, results are cached in process.
 func (vm *VM) GetCode(checksum Checksum) (WasmCode, error) {
-	return api.GetCode(vm.cache, checksum)
+	if vm.codeCache == nil {
+		return api.GetCode(vm.cache, checksum)
+	}
+	if code, ok := vm.codeCache.get(checksum); ok {
+		return code, nil
+	}
+	code, err := api.GetCode(vm.cache, checksum)
+	if err != nil {
+		return nil, err
+	}
+	vm.codeCache.add(checksum, code)
+	return code, nil
+}
+
+// GetCodes returns the checksums of all codes stored in the cache in ascending order
+func (vm *VM) GetCodes() ([]Checksum, error) {
+	raw, err := api.GetCodes(vm.cache)
+	if err != nil {
+		return nil, err
+	}
+	checksums := make([]Checksum, len(raw))
+	for i, checksum := range raw {
+		checksums[i] = checksum
+	}
+	return checksums, nil
+}
+
+// defaultCodeChunkSize is the default buffer size used by GetCodeTo
+const defaultCodeChunkSize = 256 * 1024
+
+// GetCodeTo writes the original wasm code for the given checksum to w and returns the number of bytes
+// written. Unlike GetCode, the code is streamed from disk in chunks of VMConfig.CodeChunkSize bytes
+// and never held in memory as a whole.
+//
+// libwasmvm does not support streaming, so the code is read from the cache directory. This is only
+// possible for caches created by the libwasmvm version in use, otherwise types.ErrCacheVersionMismatch
+// is returned (see MigrateCache). An error wrapping types.ErrCodeNotFound is returned if no code is
+// stored for checksum. The code is verified against the checksum while it is written, and an error
+// wrapping types.ErrChecksumMismatch is returned at the end if it does not match.
+func (vm *VM) GetCodeTo(checksum Checksum, w io.Writer) (int64, error) {
+	return api.GetCodeTo(vm.cache, checksum, w, vm.codeChunkSize)
+}
+
+// Reconcile checks that the code for each of the expected checksums is stored in the cache
+// and matches its checksum. This is useful to verify the code store against chain state, e.g. after
+// an upgrade.
+//
+// `missing` contains the checksums for which no code is stored. `corrupt` contains the checksums
+// for which the stored code does not hash to the checksum. An error is returned if the code
+// cannot be loaded for other reasons.
+func (vm *VM) Reconcile(expected []Checksum) (missing []Checksum, corrupt []Checksum, err error) {
+	for _, checksum := range expected {
+		_, err := api.GetCode(vm.cache, checksum)
+		if err == nil {
+			continue
+		}
+		// The error messages are defined in cosmwasm-vm (CacheError in load_wasm_from_disk
+		// and IntegrityErr, which is returned when the hash of the loaded code does not match)
+		switch {
+		case strings.Contains(err.Error(), "Error opening Wasm file for reading"):
+			missing = append(missing, checksum)
+		case strings.Contains(err.Error(), "Hash doesn't match stored data"):
+			corrupt = append(corrupt, checksum)
+		default:
+			return nil, nil, err
+		}
+	}
+	return missing, corrupt, nil
 }
 
 // Pin pins a code to an in-memory cache, such that is
 // always loaded quickly when executed.
 // Pin is idempotent.
 func (vm *VM) Pin(checksum Checksum) error {
-	return api.Pin(vm.cache, checksum)
+	if err := api.Pin(vm.cache, checksum); err != nil {
+		return err
+	}
+	vm.pinnedMutex.Lock()
+	defer vm.pinnedMutex.Unlock()
+	vm.pinned[string(checksum)] = struct{}{}
+	return nil
 }
 
 // Unpin removes the guarantee of a contract to be pinned (see Pin).
@@ -92,21 +493,495 @@ func (vm *VM) Pin(checksum Checksum) error {
 // the implementor's choice.
 // Unpin is idempotent.
 func (vm *VM) Unpin(checksum Checksum) error {
-	return api.Unpin(vm.cache, checksum)
+	if err := api.Unpin(vm.cache, checksum); err != nil {
+		return err
+	}
+	vm.pinnedMutex.Lock()
+	defer vm.pinnedMutex.Unlock()
+	delete(vm.pinned, string(checksum))
+	return nil
+}
+
+// PinMany pins the given codes in order (see Pin), e.g. to warm the cache on startup.
+// It stops at the first failure, which is returned as types.PinManyError containing the failing
+// checksum and the number of codes pinned before it.
+func (vm *VM) PinMany(checksums []Checksum) error {
+	raw := make([][]byte, len(checksums))
+	for i, checksum := range checksums {
+		raw[i] = checksum
+	}
+	err := api.PinMany(vm.cache, raw)
+	pinned := len(checksums)
+	var pinErr types.PinManyError
+	if errors.As(err, &pinErr) {
+		pinned = pinErr.Pinned
+	}
+	vm.pinnedMutex.Lock()
+	defer vm.pinnedMutex.Unlock()
+	for _, checksum := range checksums[:pinned] {
+		vm.pinned[string(checksum)] = struct{}{}
+	}
+	return err
+}
+
+// RemoveCode deletes the stored Wasm code and its compiled module from disk, e.g. when a code id
+// was pruned by governance. Pinned code must be unpinned first, otherwise types.ErrCodePinned
+// is returned. types.ErrCodeNotFound is returned if no code is stored for checksum.
+//
+// WARNING: libwasmvm offers no way to remove a module from the in-memory cache. If the code was
+// executed before, its module remains there, so GetMetrics().ElementsMemoryCache does not decrease
+// and the code can still be instantiated and executed through this VM until the module is evicted
+// or the VM is restarted. Callers must stop referencing the checksum themselves, e.g. by removing
+// the code id first.
+func (vm *VM) RemoveCode(checksum Checksum) error {
+	vm.pinnedMutex.Lock()
+	_, pinned := vm.pinned[string(checksum)]
+	vm.pinnedMutex.Unlock()
+	if pinned {
+		return fmt.Errorf("%w: %X", types.ErrCodePinned, []byte(checksum))
+	}
+	if vm.codeCache != nil {
+		vm.codeCache.remove(checksum)
+	}
+	if err := api.RemoveCode(vm.cache, checksum); err != nil {
+		return err
+	}
+	vm.replyHandlers.Delete(string(checksum))
+	vm.grantsMutex.Lock()
+	delete(vm.capabilityGrants, string(checksum))
+	vm.grantsMutex.Unlock()
+	return nil
+}
+
+// UnpinAll unpins all codes pinned via this VM instance (see Unpin).
+// All codes are attempted and the errors are joined.
+func (vm *VM) UnpinAll() error {
+	vm.pinnedMutex.Lock()
+	checksums := make([]Checksum, 0, len(vm.pinned))
+	for checksum := range vm.pinned {
+		checksums = append(checksums, Checksum(checksum))
+	}
+	vm.pinnedMutex.Unlock()
+
+	var errs []error
+	for _, checksum := range checksums {
+		if err := vm.Unpin(checksum); err != nil {
+			errs = append(errs, fmt.Errorf("unpinning %X: %w", []byte(checksum), err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // Returns a report of static analysis of the wasm contract (uncompiled).
 // This contract must have been stored in the cache previously (via Create).
-// Only info currently returned is if it exposes all ibc entry points, but this may grow later
+// The report contains the required capabilities, the entry points and if all IBC entry points are exposed.
 func (vm *VM) AnalyzeCode(checksum Checksum) (*types.AnalysisReport, error) {
 	return api.AnalyzeCode(vm.cache, checksum)
 }
 
+// EntryPointGasConfig returns the base gas charged for each entry point before the contract is executed.
+func (vm *VM) EntryPointGasConfig() types.EntryPointGas {
+	return vm.entryPointGas
+}
+
+// CheckMigrateCompatibility analyzes if a contract using the old code can be migrated to the new code.
+// Both codes must have been stored in the cache previously (via Create).
+func (vm *VM) CheckMigrateCompatibility(oldChecksum, newChecksum Checksum) (*types.MigrateCompat, error) {
+	oldCode, err := vm.GetCode(oldChecksum)
+	if err != nil {
+		return nil, err
+	}
+	newCode, err := vm.GetCode(newChecksum)
+	if err != nil {
+		return nil, err
+	}
+	oldInfo, err := api.AnalyzeMigrate(oldCode)
+	if err != nil {
+		return nil, err
+	}
+	newInfo, err := api.AnalyzeMigrate(newCode)
+	if err != nil {
+		return nil, err
+	}
+	return &types.MigrateCompat{
+		HasMigrateEntryPoint: newInfo.HasMigrateEntryPoint,
+		OldVersion:           oldInfo.Version,
+		NewVersion:           newInfo.Version,
+		Downgrade:            oldInfo.Version != nil && newInfo.Version != nil && *newInfo.Version < *oldInfo.Version,
+	}, nil
+}
+
+// GetInterfaceVersion returns the interface version marker the contract was built with, e.g. "interface_version_8".
+// It returns types.ErrMissingInterfaceVersion if the contract does not declare one.
+// This contract must have been stored in the cache previously (via Create).
+func (vm *VM) GetInterfaceVersion(checksum Checksum) (string, error) {
+	code, err := vm.GetCode(checksum)
+	if err != nil {
+		return "", err
+	}
+	return api.InterfaceVersion(code)
+}
+
+// ListEntryPoints returns the names of the entry points exported by the contract in alphabetical order.
+// Technical exports like allocate or interface_version_* are not included.
+// This contract must have been stored in the cache previously (via Create).
+func (vm *VM) ListEntryPoints(checksum Checksum) ([]string, error) {
+	code, err := vm.GetCode(checksum)
+	if err != nil {
+		return nil, err
+	}
+	return api.ListEntryPoints(code)
+}
+
+// AcceptsExecuteVariant returns true if the contract accepts execute messages of the given top-level variant,
+// e.g. "release" for {"release":{}}. This allows rejecting messages cheaply before running the contract.
+// The variants are taken from an ExecuteMsg schema the chain injected into the code before storing it
+// (see api.ExecuteVariants). types.ErrNoVariantInfo is returned if the code does not contain one, which is
+// the case for all contracts as built by the CosmWasm toolchain.
+func (vm *VM) AcceptsExecuteVariant(checksum Checksum, variant string) (bool, error) {
+	code, err := vm.GetCode(checksum)
+	if err != nil {
+		return false, err
+	}
+	variants, err := api.ExecuteVariants(code)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range variants {
+		if v == variant {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// unmarshal decodes a contract result, using strict number handling if configured
+func (vm *VM) unmarshal(data []byte, v interface{}) error {
+	if vm.strictJSON {
+		return types.UnmarshalStrict(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// checkResponse validates the messages, attributes and events of a decoded contract response
+// against the contract's capabilities and the configured limits. If configured, attributes and
+// events are sorted in place (see VMConfig.CanonicalEvents).
+func (vm *VM) checkResponse(checksum Checksum, msgs []types.SubMsg, attributes []types.EventAttribute, events []types.Event) error {
+	if vm.canonicalEvents {
+		types.EventAttributes(attributes).SortCanonical()
+		types.Events(events).SortCanonical()
+	}
+	limits := types.VMConfig{
+		MaxEventsPerResponse:     vm.maxEvents,
+		MaxAttributesPerResponse: vm.maxAttributes,
+		UniqueEventTypes:         vm.uniqueEventTypes,
+		StrictSubMessages:        vm.strictSubMsgs,
+	}
+	if err := types.ValidateResponseParts(limits, msgs, attributes, events); err != nil {
+		return err
+	}
+	return vm.checkReplyHandler(checksum, msgs)
+}
+
+// checkReplyHandler ensures the contract exports a reply entry point if any of the submessages
+// expects a reply. The result is cached per checksum.
+func (vm *VM) checkReplyHandler(checksum Checksum, msgs []types.SubMsg) error {
+	expectsReply := false
+	for _, msg := range msgs {
+		if msg.ReplyOn != types.ReplyNever {
+			expectsReply = true
+			break
+		}
+	}
+	if !expectsReply {
+		return nil
+	}
+
+	hasReply, ok := vm.replyHandlers.Load(string(checksum))
+	if !ok {
+		entryPoints, err := vm.ListEntryPoints(checksum)
+		if err != nil {
+			return err
+		}
+		i := sort.SearchStrings(entryPoints, "reply")
+		hasReply = i < len(entryPoints) && entryPoints[i] == "reply"
+		vm.replyHandlers.Store(string(checksum), hasReply)
+	}
+	if !hasReply.(bool) {
+		return types.ErrNoReplyHandler
+	}
+	return nil
+}
+
 // GetMetrics some internal metrics for monitoring purposes.
 func (vm *VM) GetMetrics() (*types.Metrics, error) {
 	return api.GetMetrics(vm.cache)
 }
 
+// ActiveCallCount returns the number of contract calls currently in progress in this VM.
+// A count that stays above 0 while no contract is executed points to a call that never returned.
+//
+// The calls are counted on the Go side, so this cannot detect contract instances that outlive
+// their call inside libwasmvm. libwasmvm does not expose the number of live instances.
+func (vm *VM) ActiveCallCount() (uint64, error) {
+	return uint64(vm.activeCalls.Load()), nil
+}
+
+// startCall counts a contract call as active until the returned function is called
+func (vm *VM) startCall() func() {
+	vm.activeCalls.Add(1)
+	return func() {
+		vm.activeCalls.Add(-1)
+	}
+}
+
+// audit reports a finished contract call to the AuditSink, if configured
+func (vm *VM) audit(checksum Checksum, entryPoint string, sender string, gasUsed uint64, err error) {
+	if vm.auditSink == nil {
+		return
+	}
+	entry := types.AuditEntry{
+		Checksum:   checksum,
+		EntryPoint: entryPoint,
+		Sender:     sender,
+		GasUsed:    gasUsed,
+	}
+	if err != nil {
+		entry.Err = err.Error()
+	}
+	vm.auditSink(entry)
+}
+
+// auditSender returns the sender of the encoded MessageInfo for the AuditSink
+func (vm *VM) auditSender(encodedInfo []byte) string {
+	if vm.auditSink == nil {
+		return ""
+	}
+	var info types.MessageInfo
+	if err := json.Unmarshal(encodedInfo, &info); err != nil {
+		return ""
+	}
+	return info.Sender
+}
+
+// checkCacheSoftLimit calls the OnCacheSoftLimit callback when the memory cache utilization
+// crossed the configured ratio since the last check. It is called after each contract call since
+// those are the points at which modules are inserted into the memory cache.
+func (vm *VM) checkCacheSoftLimit() {
+	if vm.onCacheSoftLimit == nil || vm.cacheSize == 0 {
+		return
+	}
+	metrics, err := vm.GetMetrics()
+	if err != nil {
+		return
+	}
+	capacity := uint64(vm.cacheSize) * mib
+	above := float64(metrics.SizeMemoryCache) >= vm.cacheSoftLimitRatio*float64(capacity)
+	vm.cacheSoftLimitMutex.Lock()
+	crossed := above && !vm.cacheAboveSoftLimit
+	vm.cacheAboveSoftLimit = above
+	vm.cacheSoftLimitMutex.Unlock()
+	if crossed {
+		vm.onCacheSoftLimit(metrics.SizeMemoryCache, capacity)
+	}
+}
+
+// StreamMetrics sends the result of GetMetrics to the returned channel every `interval` until
+// ctx is cancelled, after which the channel is closed. Errors from GetMetrics are skipped.
+// Ticks are skipped while a sample is waiting to be received.
+func (vm *VM) StreamMetrics(ctx context.Context, interval time.Duration) <-chan types.Metrics {
+	out := make(chan types.Metrics)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				metrics, err := vm.GetMetrics()
+				if err != nil {
+					continue
+				}
+				select {
+				case out <- *metrics:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// EffectiveCacheConfig returns the cache sizes the VM was configured with in bytes.
+// These are the configured values converted from MiB, not values read back from libwasmvm,
+// which does not expose the sizes it applied.
+func (vm *VM) EffectiveCacheConfig() (types.EffectiveCacheConfig, error) {
+	return types.EffectiveCacheConfig{
+		MemoryCacheSize:     uint64(vm.cacheSize) * mib,
+		InstanceMemoryLimit: uint64(vm.memoryLimit) * mib,
+	}, nil
+}
+
+// DescribeCache returns the on-disk layout of the cache for debugging purposes.
+// The directory structure is defined by cosmwasm-vm and may change with new versions.
+func (vm *VM) DescribeCache() (types.CacheLayout, error) {
+	layout := types.CacheLayout{
+		BaseDir:    vm.dataDir,
+		WasmDir:    filepath.Join(vm.dataDir, "state", "wasm"),
+		ModulesDir: filepath.Join(vm.dataDir, "cache", "modules"),
+	}
+	var err error
+	layout.WasmFiles, layout.WasmSize, err = dirStats(layout.WasmDir)
+	if err != nil {
+		return types.CacheLayout{}, err
+	}
+	layout.ModuleFiles, layout.ModulesSize, err = dirStats(layout.ModulesDir)
+	if err != nil {
+		return types.CacheLayout{}, err
+	}
+	return layout, nil
+}
+
+// CheckCacheCompatibility verifies that the cache was created by the libwasmvm version in use.
+// It returns types.ErrCacheVersionMismatch if not, or if the version which created the cache is unknown.
+// See MigrateCache.
+func (vm *VM) CheckCacheCompatibility() error {
+	return api.CheckCacheVersion(vm.dataDir)
+}
+
+// MigrateCache rebuilds all compiled modules from the stored Wasm code if CheckCacheCompatibility
+// reports a version mismatch and marks the cache as created by the libwasmvm version in use.
+// It does nothing if the cache is compatible.
+//
+// The codes are already stored on chain, so they are rebuilt without the memory checks of Create.
+// All codes are attempted. If libwasmvm rejects any of them, e.g. because it requires capabilities
+// that are not enabled anymore or it was stored with StoreCodeUnchecked, the errors are joined and
+// returned and the cache stays marked as incompatible.
+func (vm *VM) MigrateCache() error {
+	err := vm.CheckCacheCompatibility()
+	var mismatch types.ErrCacheVersionMismatch
+	if !errors.As(err, &mismatch) {
+		return err
+	}
+
+	modules, err := filepath.Glob(filepath.Join(vm.dataDir, "cache", "modules", "*", "*"))
+	if err != nil {
+		return err
+	}
+	for _, module := range modules {
+		if err := os.Remove(module); err != nil {
+			return err
+		}
+	}
+	wasmFiles, err := os.ReadDir(filepath.Join(vm.dataDir, "state", "wasm"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var errs []error
+	for _, file := range wasmFiles {
+		code, err := os.ReadFile(filepath.Join(vm.dataDir, "state", "wasm", file.Name()))
+		if err != nil {
+			return err
+		}
+		// storing the code again compiles it
+		if _, err := vm.storeCode(code); err != nil {
+			errs = append(errs, fmt.Errorf("rebuilding %s: %w", strings.ToUpper(file.Name()), err))
+		}
+	}
+	if len(errs) != 0 {
+		return errors.Join(errs...)
+	}
+	return api.WriteCacheVersion(vm.dataDir)
+}
+
+// GasScheduleFingerprint returns a hash of all gas costs applied by this VM, such that operators can
+// compare the gas schedule across nodes. It covers the libwasmvm version (which defines the instruction
+// and host function costs), the entry point base gas and the iterator gas costs.
+// Storage and query costs are charged by the caller's store and querier and are not included.
+func (vm *VM) GasScheduleFingerprint() ([]byte, error) {
+	version, err := api.LibwasmvmVersion()
+	if err != nil {
+		return nil, err
+	}
+	schedule := struct {
+		LibwasmvmVersion string
+		EntryPointGas    types.EntryPointGas
+		IteratorGasCosts types.IteratorGasCosts
+	}{
+		LibwasmvmVersion: version,
+		EntryPointGas:    vm.entryPointGas,
+		IteratorGasCosts: vm.iteratorGasCosts,
+	}
+	// the JSON encoding of structs is deterministic
+	bz, err := json.Marshal(schedule)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(bz)
+	return hash[:], nil
+}
+
+// ExportState calls fn for each entry of the contract's store whose key starts with prefix, in
+// ascending key order, and stops at the first error returned by fn. An empty prefix exports the
+// whole store. This is meant for state export tooling, where store should be backed by an
+// infinite gas meter since the iteration is charged as usual.
+func (vm *VM) ExportState(store KVStore, prefix []byte, fn func(key, value []byte) error) error {
+	iter, err := api.DumpContractState(store, prefix)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// CompiledModuleHash returns a hash of the compiled module of the given code as stored in the file
+// system cache. The hash covers the module format version directory (which contains the compiler
+// version) and the serialized module. Unlike the checksum, it changes when the compiler changes, which
+// allows detecting incompatible precompiled caches.
+func (vm *VM) CompiledModuleHash(checksum Checksum) ([]byte, error) {
+	modulesDir := filepath.Join(vm.dataDir, "cache", "modules")
+	matches, err := filepath.Glob(filepath.Join(modulesDir, "*", hex.EncodeToString(checksum)))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("expected one compiled module for checksum %X, found %d", []byte(checksum), len(matches))
+	}
+	module, err := os.ReadFile(matches[0])
+	if err != nil {
+		return nil, err
+	}
+	version := filepath.Base(filepath.Dir(matches[0]))
+	hash := sha256.New()
+	hash.Write([]byte(version))
+	hash.Write([]byte{0})
+	hash.Write(module)
+	return hash.Sum(nil), nil
+}
+
+// dirStats returns the number and cumulative size of all files in dir and its subdirectories
+func dirStats(dir string) (count uint64, size uint64, err error) {
+	err = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			count++
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return count, size, err
+}
+
 // Instantiate will create a new contract based on the given Checksum.
 // We can set the initMsg (contract "genesis") here, and it then receives
 // an account and address and can be invoked (Execute) many times.
@@ -126,7 +1001,10 @@ func (vm *VM) Instantiate(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.Response, uint64, error) {
+) (res *types.Response, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "instantiate", info.Sender, gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -135,28 +1013,74 @@ func (vm *VM) Instantiate(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.Instantiate(vm.cache, checksum, envBin, infoBin, initMsg, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.Instantiate
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.Instantiate(vm.cache, checksum, envBin, infoBin, initMsg, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var result types.ContractResult
-	err = json.Unmarshal(data, &result)
+	err = vm.unmarshal(data, &result)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if result.Err != "" {
 		return nil, gasUsed, fmt.Errorf("%s", result.Err)
 	}
+	if result.Ok != nil {
+		if err := vm.checkResponse(checksum, result.Ok.Messages, result.Ok.Attributes, result.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return result.Ok, gasUsed, nil
 }
 
+// Instantiate2 is like Instantiate, but sets the address of the new contract in env to the one derived from
+// checksum, the sender in info and salt by Instantiate2Address, which is returned alongside the result.
+// Instantiating the same code from the same sender with the same salt always yields the same address, so
+// the caller must reject the instantiation if a contract already exists at the returned address.
+//
+// The salt itself is not passed to the contract. The address conversions of goapi are not charged any gas.
+func (vm *VM) Instantiate2(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	initMsg []byte,
+	salt []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (types.HumanAddress, *types.Response, uint64, error) {
+	if len(salt) == 0 || len(salt) > 64 {
+		return "", nil, 0, fmt.Errorf("salt must be between 1 and 64 bytes, got %d", len(salt))
+	}
+	creator, _, err := goapi.CanonicalAddress(info.Sender)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid sender address: %w", err)
+	}
+	address, _, err := goapi.HumanAddress(Instantiate2Address(checksum, creator, salt, nil))
+	if err != nil {
+		return "", nil, 0, err
+	}
+	env.Contract.Address = address
+	res, gasUsed, err := vm.Instantiate(checksum, env, info, initMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return address, res, gasUsed, err
+}
+
 // Execute calls a given contract. Since the only difference between contracts with the same Checksum is the
 // data in their local storage, and their address in the outside world, we need no ContractID here.
 // (That is a detail for the external, sdk-facing, side).
@@ -179,35 +1103,204 @@ func (vm *VM) Execute(
 	if err != nil {
 		return nil, 0, err
 	}
+	return vm.ExecutePreEncoded(checksum, envBin, info, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+}
+
+// ExecuteWithOutput works like Execute but returns the result as ExecuteOutput, which can contain
+// additional information depending on the VM config. In case of an error, the output contains the gas used.
+func (vm *VM) ExecuteWithOutput(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.ExecuteOutput, error) {
+	envBin, err := json.Marshal(env)
+	if err != nil {
+		return &types.ExecuteOutput{}, err
+	}
+	infoBin, err := json.Marshal(info)
+	if err != nil {
+		return &types.ExecuteOutput{}, err
+	}
+	counter := newHostCallCounter()
+	store, goapi, querier = counter.wrap(store, goapi, querier)
+	metricsBefore, metricsErr := api.GetMetrics(vm.cache)
+	start := vm.clock()
+	res, gasUsed, deserGas, err := vm.executeEncoded(checksum, envBin, infoBin, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	elapsed := vm.clock().Sub(start)
+	output := types.ExecuteOutput{
+		Response:           res,
+		GasUsed:            gasUsed,
+		DeserializationGas: deserGas,
+		HostCallCounts:     counter.Counts(),
+	}
+	// libwasmvm does not report the compile step separately, so it is detected from the cache metrics
+	if metricsErr == nil {
+		if metricsAfter, err := api.GetMetrics(vm.cache); err == nil && compiled(metricsBefore, metricsAfter) {
+			output.ColdCallDuration = elapsed
+		}
+	}
+	if vm.echoEnv {
+		output.Env = &env
+	}
+	if vm.captureInputs {
+		output.RawInputs = &types.RawInputs{
+			Env:  envBin,
+			Info: infoBin,
+			Msg:  executeMsg,
+		}
+	}
+	return &output, err
+}
+
+// ExecuteRaw works like Execute but additionally returns the JSON encoding of the response as returned
+// by the contract, for callers that forward it without encoding the decoded response again.
+// The raw events and attributes are not affected by VMConfig.CanonicalEvents.
+// The raw response is nil if the contract result could not be decoded.
+func (vm *VM) ExecuteRaw(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.Response, *types.RawResponse, uint64, error) {
+	envBin, err := json.Marshal(env)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	infoBin, err := json.Marshal(info)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	res, data, gasUsed, _, err := vm.executeEncodedRaw(checksum, envBin, infoBin, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	if data == nil {
+		return res, nil, gasUsed, err
+	}
+	raw, rawErr := types.NewRawResponse(data)
+	if rawErr != nil && err == nil {
+		return nil, nil, gasUsed, rawErr
+	}
+	return res, raw, gasUsed, err
+}
+
+// compiled returns true if a module was in none of the caches and had to be compiled between the two metrics
+func compiled(before, after *types.Metrics) bool {
+	return after.Misses > before.Misses
+}
+
+// ExecutePreEncoded works like Execute but takes the JSON encoded env. This allows callers to encode
+// the env once and reuse it for many calls, e.g. for all executions in one block.
+//
+// The caller is responsible for passing a valid encoding of types.Env. It is not checked on the Go side.
+func (vm *VM) ExecutePreEncoded(
+	checksum Checksum,
+	encodedEnv []byte,
+	info types.MessageInfo,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.Response, uint64, error) {
 	infoBin, err := json.Marshal(info)
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.Execute(vm.cache, checksum, envBin, infoBin, executeMsg, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	res, gasUsed, _, err := vm.executeEncoded(checksum, encodedEnv, infoBin, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return res, gasUsed, err
+}
+
+// executeEncoded calls the execute entry point with the JSON encoded env and info.
+// Besides the total gas used, it returns the part of it charged for deserializing the result.
+func (vm *VM) executeEncoded(
+	checksum Checksum,
+	encodedEnv []byte,
+	encodedInfo []byte,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.Response, uint64, uint64, error) {
+	res, _, gasUsed, deserGas, err := vm.executeEncodedRaw(checksum, encodedEnv, encodedInfo, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return res, gasUsed, deserGas, err
+}
+
+// executeEncodedRaw works like executeEncoded and additionally returns the contract result as returned by
+// the contract if it could be decoded
+func (vm *VM) executeEncodedRaw(
+	checksum Checksum,
+	encodedEnv []byte,
+	encodedInfo []byte,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (res *types.Response, data []byte, gasUsed uint64, deserGas uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "execute", vm.auditSender(encodedInfo), gasUsed, err) }()
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.Execute
+	if gasLimit < baseGas {
+		return nil, nil, gasLimit, 0, types.OutOfGasError{}
+	}
+	data, gasUsed, err = api.Execute(vm.cache, checksum, encodedEnv, encodedInfo, executeMsg, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
-		return nil, gasUsed, err
+		return nil, nil, gasUsed, 0, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
-		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
+		return nil, nil, gasUsed, 0, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 
 	gasUsed += gasForDeserialization
 	var result types.ContractResult
-	err = json.Unmarshal(data, &result)
+	err = vm.unmarshal(data, &result)
 	if err != nil {
-		return nil, gasUsed, err
+		return nil, nil, gasUsed, gasForDeserialization, err
 	}
 	if result.Err != "" {
-		return nil, gasUsed, fmt.Errorf("%s", result.Err)
+		return nil, data, gasUsed, gasForDeserialization, fmt.Errorf("%s", result.Err)
 	}
-	return result.Ok, gasUsed, nil
+	if result.Ok != nil {
+		if err := vm.checkResponse(checksum, result.Ok.Messages, result.Ok.Attributes, result.Ok.Events); err != nil {
+			return nil, data, gasUsed, gasForDeserialization, err
+		}
+	}
+	return result.Ok, data, gasUsed, gasForDeserialization, nil
 }
 
 // Query allows a client to execute a contract-specific query. If the result is not empty, it should be
 // valid json-encoded data to return to the client.
 // The meaning of path and data can be determined by the code. Path is the suffix of the abci.QueryRequest.Path
+//
+// A panic of the contract is returned as types.ErrContractPanic. Queries cannot write to the store, so
+// the store is unchanged in that case. Panics in the Go callbacks are recovered and returned as errors.
+// The store is wrapped with api.ReadOnlyStore, so that a write reaching the Go side fails the query.
+//
+// Query is safe for concurrent use. libwasmvm creates a new instance for every call and drops it
+// afterwards, so no contract memory is shared between calls, and pinned code is only compiled once.
 func (vm *VM) Query(
 	checksum Checksum,
 	env types.Env,
@@ -218,24 +1311,33 @@ func (vm *VM) Query(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) ([]byte, uint64, error) {
+) (data []byte, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "query", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.Query(vm.cache, checksum, envBin, queryMsg, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.Query
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err = api.Query(vm.cache, checksum, envBin, queryMsg, &gasMeter, api.ReadOnlyStore(store), &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.QueryResponse
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
@@ -245,6 +1347,35 @@ func (vm *VM) Query(
 	return resp.Ok, gasUsed, nil
 }
 
+// QueryWithOutput works like Query but returns the result as QueryOutput, which can contain
+// additional information depending on the VM config. In case of an error, the output contains the gas used.
+func (vm *VM) QueryWithOutput(
+	checksum Checksum,
+	env types.Env,
+	queryMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.QueryOutput, error) {
+	var reads *readTracker
+	if vm.trackQueryReads {
+		reads = newReadTracker()
+		store = reads.wrap(store)
+	}
+	data, gasUsed, err := vm.Query(checksum, env, queryMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	output := types.QueryOutput{
+		Data:    data,
+		GasUsed: gasUsed,
+	}
+	if reads != nil {
+		output.AccessedKeys = reads.Keys()
+	}
+	return &output, err
+}
+
 // Migrate will migrate an existing contract to a new code binary.
 // This takes storage of the data from the original contract and the Checksum of the new contract that should
 // replace it. This allows it to run a migration step if needed, or return an error if unable to migrate
@@ -261,30 +1392,44 @@ func (vm *VM) Migrate(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.Response, uint64, error) {
+) (res *types.Response, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "migrate", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.Migrate(vm.cache, checksum, envBin, migrateMsg, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.Migrate
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.Migrate(vm.cache, checksum, envBin, migrateMsg, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.ContractResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if resp.Err != "" {
 		return nil, gasUsed, fmt.Errorf("%s", resp.Err)
 	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return resp.Ok, gasUsed, nil
 }
 
@@ -304,37 +1449,52 @@ func (vm *VM) Sudo(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.Response, uint64, error) {
+) (res *types.Response, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "sudo", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.Sudo(vm.cache, checksum, envBin, sudoMsg, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.Sudo
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.Sudo(vm.cache, checksum, envBin, sudoMsg, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.ContractResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if resp.Err != "" {
 		return nil, gasUsed, fmt.Errorf("%s", resp.Err)
 	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return resp.Ok, gasUsed, nil
 }
 
 // Reply allows the native Go wasm modules to make a priviledged call to return the result
 // of executing a SubMsg.
 //
-// These work much like Sudo (same scenario) but focuses on one specific case (and one message type)
+// These work much like Sudo (same scenario) but focuses on one specific case (and one message type).
+// If the contract returns an error, it is returned as types.ErrReplyFailed.
 func (vm *VM) Reply(
 	checksum Checksum,
 	env types.Env,
@@ -345,7 +1505,13 @@ func (vm *VM) Reply(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.Response, uint64, error) {
+) (res *types.Response, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "reply", "", gasUsed, err) }()
+	if reply.Result.ErrCode != nil && !vm.replyErrorCodes {
+		return nil, 0, fmt.Errorf("%w: reply %d has error code %d", types.ErrReplyErrCodeDisabled, reply.ID, *reply.Result.ErrCode)
+	}
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -354,24 +1520,35 @@ func (vm *VM) Reply(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.Reply(vm.cache, checksum, envBin, replyBin, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.Reply
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.Reply(vm.cache, checksum, envBin, replyBin, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.ContractResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if resp.Err != "" {
-		return nil, gasUsed, fmt.Errorf("%s", resp.Err)
+		return nil, gasUsed, types.ErrReplyFailed{SubMsgID: reply.ID, Msg: resp.Err}
+	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
 	}
 	return resp.Ok, gasUsed, nil
 }
@@ -388,7 +1565,10 @@ func (vm *VM) IBCChannelOpen(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.IBC3ChannelOpenResponse, uint64, error) {
+) (res *types.IBC3ChannelOpenResponse, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "ibc_channel_open", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -397,19 +1577,25 @@ func (vm *VM) IBCChannelOpen(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.IBCChannelOpen(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.IBC
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.IBCChannelOpen(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.IBCChannelOpenResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
@@ -431,7 +1617,10 @@ func (vm *VM) IBCChannelConnect(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.IBCBasicResponse, uint64, error) {
+) (res *types.IBCBasicResponse, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "ibc_channel_connect", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -440,25 +1629,36 @@ func (vm *VM) IBCChannelConnect(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.IBCChannelConnect(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.IBC
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.IBCChannelConnect(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.IBCBasicResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if resp.Err != "" {
 		return nil, gasUsed, fmt.Errorf("%s", resp.Err)
 	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return resp.Ok, gasUsed, nil
 }
 
@@ -474,7 +1674,10 @@ func (vm *VM) IBCChannelClose(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.IBCBasicResponse, uint64, error) {
+) (res *types.IBCBasicResponse, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "ibc_channel_close", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -483,25 +1686,36 @@ func (vm *VM) IBCChannelClose(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.IBCChannelClose(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.IBC
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.IBCChannelClose(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.IBCBasicResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if resp.Err != "" {
 		return nil, gasUsed, fmt.Errorf("%s", resp.Err)
 	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return resp.Ok, gasUsed, nil
 }
 
@@ -517,7 +1731,10 @@ func (vm *VM) IBCPacketReceive(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.IBCReceiveResult, uint64, error) {
+) (res *types.IBCReceiveResult, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "ibc_packet_receive", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -526,22 +1743,33 @@ func (vm *VM) IBCPacketReceive(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.IBCPacketReceive(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.IBC
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.IBCPacketReceive(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.IBCReceiveResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return &resp, gasUsed, nil
 }
 
@@ -558,7 +1786,10 @@ func (vm *VM) IBCPacketAck(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.IBCBasicResponse, uint64, error) {
+) (res *types.IBCBasicResponse, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "ibc_packet_ack", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -567,25 +1798,36 @@ func (vm *VM) IBCPacketAck(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.IBCPacketAck(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.IBC
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.IBCPacketAck(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.IBCBasicResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if resp.Err != "" {
 		return nil, gasUsed, fmt.Errorf("%s", resp.Err)
 	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return resp.Ok, gasUsed, nil
 }
 
@@ -602,7 +1844,10 @@ func (vm *VM) IBCPacketTimeout(
 	gasMeter GasMeter,
 	gasLimit uint64,
 	deserCost types.UFraction,
-) (*types.IBCBasicResponse, uint64, error) {
+) (res *types.IBCBasicResponse, gasUsed uint64, err error) {
+	defer vm.checkCacheSoftLimit()
+	defer vm.startCall()()
+	defer func() { vm.audit(checksum, "ibc_packet_timeout", "", gasUsed, err) }()
 	envBin, err := json.Marshal(env)
 	if err != nil {
 		return nil, 0, err
@@ -611,25 +1856,36 @@ func (vm *VM) IBCPacketTimeout(
 	if err != nil {
 		return nil, 0, err
 	}
-	data, gasUsed, err := api.IBCPacketTimeout(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit, vm.printDebug)
+	gasLimit = vm.gasLimitOrDefault(gasLimit)
+	baseGas := vm.entryPointGas.IBC
+	if gasLimit < baseGas {
+		return nil, gasLimit, types.OutOfGasError{}
+	}
+	data, gasUsed, err := api.IBCPacketTimeout(vm.cache, checksum, envBin, msgBin, &gasMeter, store, &goapi, &querier, gasLimit-baseGas, vm.printDebug)
+	gasUsed += baseGas
 	if err != nil {
 		return nil, gasUsed, err
 	}
 
-	gasForDeserialization := deserCost.Mul(uint64(len(data))).Floor()
+	gasForDeserialization := deserCost.DeserializationGas(len(data))
 	if gasLimit < gasForDeserialization+gasUsed {
 		return nil, gasUsed, fmt.Errorf("Insufficient gas left to deserialize contract execution result (%d bytes)", len(data))
 	}
 	gasUsed += gasForDeserialization
 
 	var resp types.IBCBasicResult
-	err = json.Unmarshal(data, &resp)
+	err = vm.unmarshal(data, &resp)
 	if err != nil {
 		return nil, gasUsed, err
 	}
 	if resp.Err != "" {
 		return nil, gasUsed, fmt.Errorf("%s", resp.Err)
 	}
+	if resp.Ok != nil {
+		if err := vm.checkResponse(checksum, resp.Ok.Messages, resp.Ok.Attributes, resp.Ok.Events); err != nil {
+			return nil, gasUsed, err
+		}
+	}
 	return resp.Ok, gasUsed, nil
 }
 
@@ -639,3 +1895,18 @@ func (vm *VM) IBCPacketTimeout(
 func LibwasmvmVersion() (string, error) {
 	return api.LibwasmvmVersion()
 }
+
+// LibwasmvmBuildInfo returns details about the linked libwasmvm build for debugging mismatches
+// between the Go code and the library, e.g. a muslc build linked on a glibc system.
+// libwasmvm does not report its build details itself, so only the version comes from the loaded
+// library and the target is derived from the build tags that selected the library to link.
+func LibwasmvmBuildInfo() (types.BuildInfo, error) {
+	version, err := api.LibwasmvmVersion()
+	if err != nil {
+		return types.BuildInfo{}, err
+	}
+	return types.BuildInfo{
+		Version: version,
+		Target:  api.LibwasmvmTarget(),
+	}, nil
+}
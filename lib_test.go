@@ -1,10 +1,20 @@
 package cosmwasm
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Finschia/wasmvm/internal/api"
 	"github.com/Finschia/wasmvm/types"
@@ -22,6 +32,7 @@ const (
 
 const CYBERPUNK_TEST_CONTRACT = "./testdata/cyberpunk.wasm"
 const HACKATOM_TEST_CONTRACT = "./testdata/hackatom.wasm"
+const REFLECT_TEST_CONTRACT = "./testdata/reflect.wasm"
 
 func withVM(t *testing.T) *VM {
 	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
@@ -36,6 +47,21 @@ func withVM(t *testing.T) *VM {
 	return vm
 }
 
+// withVMConfig creates a VM from the given config using a temporary data directory
+func withVMConfig(t *testing.T, config types.VMConfig) *VM {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	config.DataDir = tmpdir
+	vm, err := NewVMWithConfig(config)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		vm.Cleanup()
+		os.RemoveAll(tmpdir)
+	})
+	return vm
+}
+
 func createTestContract(t *testing.T, vm *VM, path string) Checksum {
 	wasm, err := ioutil.ReadFile(path)
 	require.NoError(t, err)
@@ -58,6 +84,135 @@ func TestCreateAndGet(t *testing.T) {
 	require.Equal(t, WasmCode(wasm), code)
 }
 
+func TestReconcile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	vm, err := NewVM(tmpdir, TESTING_FEATURES, TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.NoError(t, err)
+	defer vm.Cleanup()
+
+	hackatom := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	cyberpunk := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+	unknown := make(Checksum, 32)
+
+	missing, corrupt, err := vm.Reconcile([]Checksum{hackatom, cyberpunk})
+	require.NoError(t, err)
+	require.Empty(t, missing)
+	require.Empty(t, corrupt)
+
+	// corrupt the stored code of cyberpunk
+	path := filepath.Join(tmpdir, "state", "wasm", hex.EncodeToString(cyberpunk))
+	err = ioutil.WriteFile(path, []byte("\x00asm\x01\x00\x00\x00"), 0o644)
+	require.NoError(t, err)
+
+	missing, corrupt, err = vm.Reconcile([]Checksum{hackatom, cyberpunk, unknown})
+	require.NoError(t, err)
+	require.Equal(t, []Checksum{unknown}, missing)
+	require.Equal(t, []Checksum{cyberpunk}, corrupt)
+}
+
+func TestDescribeCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	vm, err := NewVM(tmpdir, TESTING_FEATURES, TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.NoError(t, err)
+	defer vm.Cleanup()
+
+	layout, err := vm.DescribeCache()
+	require.NoError(t, err)
+	require.Equal(t, tmpdir, layout.BaseDir)
+	require.Equal(t, filepath.Join(tmpdir, "state", "wasm"), layout.WasmDir)
+	require.Equal(t, filepath.Join(tmpdir, "cache", "modules"), layout.ModulesDir)
+	require.Equal(t, uint64(0), layout.WasmFiles)
+	require.Equal(t, uint64(0), layout.ModuleFiles)
+
+	hackatom, err := ioutil.ReadFile(HACKATOM_TEST_CONTRACT)
+	require.NoError(t, err)
+	cyberpunk, err := ioutil.ReadFile(CYBERPUNK_TEST_CONTRACT)
+	require.NoError(t, err)
+	_, err = vm.Create(hackatom)
+	require.NoError(t, err)
+	_, err = vm.Create(cyberpunk)
+	require.NoError(t, err)
+
+	layout, err = vm.DescribeCache()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), layout.WasmFiles)
+	require.Equal(t, uint64(len(hackatom)+len(cyberpunk)), layout.WasmSize)
+	require.Equal(t, uint64(2), layout.ModuleFiles)
+	require.Greater(t, layout.ModulesSize, uint64(0))
+}
+
+func TestCompiledModuleHash(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	hash, err := vm.CompiledModuleHash(checksum)
+	require.NoError(t, err)
+	require.Len(t, hash, 32)
+	require.NotEqual(t, []byte(checksum), hash)
+
+	hash2, err := vm.CompiledModuleHash(checksum)
+	require.NoError(t, err)
+	require.Equal(t, hash, hash2)
+
+	_, err = vm.CompiledModuleHash(make(Checksum, 32))
+	require.ErrorContains(t, err, "found 0")
+}
+
+func TestUnpinAll(t *testing.T) {
+	vm := withVM(t)
+	hackatom := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	cyberpunk := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+
+	require.NoError(t, vm.Pin(hackatom))
+	require.NoError(t, vm.Pin(cyberpunk))
+	metrics, err := vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), metrics.ElementsPinnedMemoryCache)
+
+	require.NoError(t, vm.UnpinAll())
+	metrics, err = vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), metrics.ElementsPinnedMemoryCache)
+
+	// nothing left to unpin
+	require.NoError(t, vm.UnpinAll())
+}
+
+func TestPinMany(t *testing.T) {
+	vm := withVM(t)
+	hackatom := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	cyberpunk := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+	reflect := createTestContract(t, vm, REFLECT_TEST_CONTRACT)
+
+	// pinning an already pinned code is a no-op
+	require.NoError(t, vm.Pin(hackatom))
+	require.NoError(t, vm.PinMany([]Checksum{hackatom, cyberpunk}))
+	metrics, err := vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), metrics.ElementsPinnedMemoryCache)
+
+	// partial failure
+	unknown := Checksum(make([]byte, 32))
+	err = vm.PinMany([]Checksum{reflect, unknown, hackatom})
+	var pinErr types.PinManyError
+	require.ErrorAs(t, err, &pinErr)
+	require.Equal(t, []byte(unknown), pinErr.Checksum)
+	require.Equal(t, 1, pinErr.Pinned)
+	metrics, err = vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), metrics.ElementsPinnedMemoryCache)
+
+	// all successfully pinned codes are tracked
+	require.NoError(t, vm.UnpinAll())
+	metrics, err = vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), metrics.ElementsPinnedMemoryCache)
+}
+
 func TestHappyPath(t *testing.T) {
 	vm := withVM(t)
 	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
@@ -159,6 +314,24 @@ func TestEnv(t *testing.T) {
 	require.Equal(t, expected, ires.Data)
 }
 
+func TestStreamMetrics(t *testing.T) {
+	vm := withVM(t)
+	createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	samples := vm.StreamMetrics(ctx, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		metrics, ok := <-samples
+		require.True(t, ok)
+		require.Equal(t, types.Metrics{}, metrics)
+	}
+	cancel()
+
+	// channel gets closed after cancellation
+	for range samples {
+	}
+}
+
 func TestGetMetrics(t *testing.T) {
 	vm := withVM(t)
 
@@ -283,3 +456,1417 @@ func TestLibwasmvmVersion(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "1.1.1-0.12.0", version)
 }
+
+func TestLibwasmvmBuildInfo(t *testing.T) {
+	info, err := LibwasmvmBuildInfo()
+	require.NoError(t, err)
+	require.Equal(t, "1.1.1-0.12.0", info.Version)
+	require.Equal(t, api.LibwasmvmTarget(), info.Target)
+}
+
+func TestEntryPointGas(t *testing.T) {
+	instantiate := func(vm *VM, gasLimit uint64) (uint64, error) {
+		checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+		gasMeter := api.NewMockGasMeter(gasLimit)
+		store := api.NewLookup(gasMeter)
+		goapi := api.NewMockAPI()
+		querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+		env := api.MockEnv()
+		info := api.MockInfo("creator", nil)
+		msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+		_, gasUsed, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, gasLimit, types.UFraction{Numerator: 1, Denominator: 1})
+		return gasUsed, err
+	}
+
+	// no base gas by default
+	vm := withVM(t)
+	require.Equal(t, types.EntryPointGas{}, vm.EntryPointGasConfig())
+	contractGas, err := instantiate(vm, TESTING_GAS_LIMIT)
+	require.NoError(t, err)
+
+	config := types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		PrintDebug:        TESTING_PRINT_DEBUG,
+		CacheSize:         TESTING_CACHE_SIZE,
+		EntryPointGas: types.EntryPointGas{
+			Instantiate: 1_000_000,
+			Execute:     2_000_000,
+		},
+	}
+	vm2 := withVMConfig(t, config)
+	require.Equal(t, config.EntryPointGas, vm2.EntryPointGasConfig())
+
+	gasUsed, err := instantiate(vm2, TESTING_GAS_LIMIT)
+	require.NoError(t, err)
+	require.Equal(t, contractGas+1_000_000, gasUsed)
+
+	// the base gas alone exceeds the limit
+	gasUsed, err = instantiate(vm2, 999_999)
+	require.Equal(t, types.OutOfGasError{}, err)
+	require.Equal(t, uint64(999_999), gasUsed)
+}
+
+func TestMemoryPagesConfig(t *testing.T) {
+	config := types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+	}
+
+	// inconsistent configs
+	invalid := config
+	invalid.MaxMemoryPages = TESTING_MEMORY_LIMIT*16 + 1
+	_, err := NewVMWithConfig(invalid)
+	require.ErrorIs(t, err, types.ErrMemoryConfigInvalid)
+	invalid = config
+	invalid.InitialMemoryPages = 20
+	invalid.MaxMemoryPages = 18
+	_, err = NewVMWithConfig(invalid)
+	require.ErrorIs(t, err, types.ErrMemoryConfigInvalid)
+
+	wasm, err := ioutil.ReadFile(HACKATOM_TEST_CONTRACT)
+	require.NoError(t, err)
+
+	// hackatom requests 17 pages initially
+	limited := config
+	limited.MaxMemoryPages = 16
+	vm := withVMConfig(t, limited)
+	_, err = vm.Create(wasm)
+	require.EqualError(t, err, "initial memory of 17 pages exceeds the maximum of 16 pages")
+
+	limited = config
+	limited.InitialMemoryPages = 16
+	vm = withVMConfig(t, limited)
+	_, err = vm.Create(wasm)
+	require.EqualError(t, err, "initial memory of 17 pages exceeds the limit of 16 pages")
+
+	limited = config
+	limited.InitialMemoryPages = 17
+	vm = withVMConfig(t, limited)
+	_, err = vm.Create(wasm)
+	require.NoError(t, err)
+
+	// hackatom does not declare a maximum memory size, so it could grow beyond any limit
+	limited = config
+	limited.MaxMemoryPages = 32
+	vm = withVMConfig(t, limited)
+	_, err = vm.Create(wasm)
+	require.EqualError(t, err, "memory without declared maximum exceeds the limit of 32 pages")
+}
+
+func TestExecutePreEncoded(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	_, _, err := vm.Instantiate(checksum, env, info, []byte(`{}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	encodedEnv, err := json.Marshal(env)
+	require.NoError(t, err)
+	msg := []byte(`{"mirror_env": {}}`)
+	res, gasUsed, err := vm.ExecutePreEncoded(checksum, encodedEnv, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, encodedEnv, res.Data)
+
+	// same as with the struct
+	res2, gasUsed2, err := vm.Execute(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, res, res2)
+	require.Equal(t, gasUsed, gasUsed2)
+}
+
+func BenchmarkExecuteEnv(b *testing.B) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(b, err)
+	defer os.RemoveAll(tmpdir)
+	vm, err := NewVM(tmpdir, TESTING_FEATURES, TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.NoError(b, err)
+	defer vm.Cleanup()
+	wasm, err := ioutil.ReadFile(CYBERPUNK_TEST_CONTRACT)
+	require.NoError(b, err)
+	checksum, err := vm.Create(wasm)
+	require.NoError(b, err)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	_, _, err = vm.Instantiate(checksum, env, info, []byte(`{}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(b, err)
+	msg := []byte(`{"mirror_env": {}}`)
+
+	b.Run("Execute", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _, err := vm.Execute(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+			require.NoError(b, err)
+		}
+	})
+	b.Run("ExecutePreEncoded", func(b *testing.B) {
+		encodedEnv, err := json.Marshal(env)
+		require.NoError(b, err)
+		for i := 0; i < b.N; i++ {
+			_, _, err := vm.ExecutePreEncoded(checksum, encodedEnv, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+			require.NoError(b, err)
+		}
+	})
+}
+
+func TestDeadlineWithFakeClock(t *testing.T) {
+	vm := withVM(t)
+	now := time.Unix(1_600_000_000, 0)
+	vm.SetClock(func() time.Time { return now })
+
+	d := vm.newDeadline(5 * time.Second)
+	require.False(t, d.Exceeded())
+	now = now.Add(5 * time.Second)
+	require.False(t, d.Exceeded())
+	now = now.Add(time.Nanosecond)
+	require.True(t, d.Exceeded())
+}
+
+func TestExecuteWithOutputEchoEnv(t *testing.T) {
+	config := types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+	}
+	for _, echoEnv := range []bool{false, true} {
+		config.EchoEnv = echoEnv
+		vm := withVMConfig(t, config)
+		checksum := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+
+		deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+		gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+		store := api.NewLookup(gasMeter)
+		goapi := api.NewMockAPI()
+		querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+		env := api.MockEnv()
+		info := api.MockInfo("creator", nil)
+		_, _, err := vm.Instantiate(checksum, env, info, []byte(`{}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+		require.NoError(t, err)
+
+		output, err := vm.ExecuteWithOutput(checksum, env, info, []byte(`{"mirror_env": {}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+		require.NoError(t, err)
+		require.NotZero(t, output.GasUsed)
+		expected, _ := json.Marshal(env)
+		require.Equal(t, expected, output.Response.Data)
+		if echoEnv {
+			require.Equal(t, &env, output.Env)
+		} else {
+			require.Nil(t, output.Env)
+		}
+	}
+}
+
+func TestCheckMigrateCompatibility(t *testing.T) {
+	vm := withVM(t)
+	hackatom := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	cyberpunk := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+
+	// none of the test contracts declares a migrate version
+	compat, err := vm.CheckMigrateCompatibility(cyberpunk, hackatom)
+	require.NoError(t, err)
+	require.Equal(t, &types.MigrateCompat{HasMigrateEntryPoint: true}, compat)
+
+	compat, err = vm.CheckMigrateCompatibility(hackatom, cyberpunk)
+	require.NoError(t, err)
+	require.Equal(t, &types.MigrateCompat{HasMigrateEntryPoint: false}, compat)
+
+	_, err = vm.CheckMigrateCompatibility(hackatom, make(Checksum, 32))
+	require.Error(t, err)
+}
+
+func TestExecuteWithOutputHostCallCounts(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter1 := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter1)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter1, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	// release reads the config and queries the balance
+	gasMeter2 := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store.SetGasMeter(gasMeter2)
+	info = api.MockInfo("fred", nil)
+	output, err := vm.ExecuteWithOutput(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter2, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), output.HostCallCounts[HostCallGet])
+	require.Equal(t, uint64(1), output.HostCallCounts[HostCallQuery])
+	require.Zero(t, output.HostCallCounts[HostCallSet])
+
+	// storage loop writes until it runs out of gas
+	gasMeter3 := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store.SetGasMeter(gasMeter3)
+	output, err = vm.ExecuteWithOutput(checksum, env, info, []byte(`{"storage_loop":{}}`), store, *goapi, querier, gasMeter3, TESTING_GAS_LIMIT, deserCost)
+	require.Error(t, err)
+	require.Greater(t, output.HostCallCounts[HostCallSet], uint64(100))
+}
+
+func TestCheckReplyHandler(t *testing.T) {
+	vm := withVM(t)
+	hackatom := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	reflect := createTestContract(t, vm, REFLECT_TEST_CONTRACT)
+
+	entryPoints, err := vm.ListEntryPoints(hackatom)
+	require.NoError(t, err)
+	require.Contains(t, entryPoints, "execute")
+	require.NotContains(t, entryPoints, "reply")
+	require.NotContains(t, entryPoints, "allocate")
+
+	never := []types.SubMsg{{ID: 1, ReplyOn: types.ReplyNever}}
+	always := []types.SubMsg{{ID: 2, ReplyOn: types.ReplyAlways}}
+
+	require.NoError(t, vm.checkReplyHandler(hackatom, nil))
+	require.NoError(t, vm.checkReplyHandler(hackatom, never))
+	require.ErrorIs(t, vm.checkReplyHandler(hackatom, always), types.ErrNoReplyHandler)
+	// cached result
+	require.ErrorIs(t, vm.checkReplyHandler(hackatom, always), types.ErrNoReplyHandler)
+	require.NoError(t, vm.checkReplyHandler(reflect, always))
+}
+
+func TestStrictJSON(t *testing.T) {
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		StrictJSON:        true,
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	balance := types.Coins{types.NewCoin(1<<60, "ATOM")}
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, balance)
+
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	info = api.MockInfo("fred", nil)
+	res, _, err := vm.Execute(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Messages))
+	require.NotNil(t, res.Messages[0].Msg.Bank)
+	assert.Equal(t, balance, res.Messages[0].Msg.Bank.Send.Amount)
+}
+
+func TestGetInterfaceVersion(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	version, err := vm.GetInterfaceVersion(checksum)
+	require.NoError(t, err)
+	assert.Equal(t, "interface_version_8", version)
+}
+
+func TestResponseLimits(t *testing.T) {
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures:        TESTING_FEATURES,
+		MemoryLimit:              TESTING_MEMORY_LIMIT,
+		CacheSize:                TESTING_CACHE_SIZE,
+		MaxEventsPerResponse:     2,
+		MaxAttributesPerResponse: 1,
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	// crafted responses
+	event := types.Event{Type: "foo"}
+	attr := types.EventAttribute{Key: "a", Value: "b"}
+	err := vm.checkResponse(checksum, nil, nil, []types.Event{event, event})
+	require.NoError(t, err)
+	err = vm.checkResponse(checksum, nil, nil, []types.Event{event, event, event})
+	require.ErrorIs(t, err, types.ErrTooManyEvents)
+	err = vm.checkResponse(checksum, nil, []types.EventAttribute{attr}, nil)
+	require.NoError(t, err)
+	err = vm.checkResponse(checksum, nil, []types.EventAttribute{attr}, []types.Event{{Type: "foo", Attributes: types.EventAttributes{attr}}})
+	require.ErrorIs(t, err, types.ErrTooManyAttributes)
+
+	// hackatom's release returns two attributes
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err = vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	info = api.MockInfo("fred", nil)
+	_, _, err = vm.Execute(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorIs(t, err, types.ErrTooManyAttributes)
+}
+
+func TestEffectiveCacheConfig(t *testing.T) {
+	vm := withVM(t)
+	config, err := vm.EffectiveCacheConfig()
+	require.NoError(t, err)
+	require.Equal(t, types.EffectiveCacheConfig{
+		MemoryCacheSize:     TESTING_CACHE_SIZE * 1024 * 1024,
+		InstanceMemoryLimit: TESTING_MEMORY_LIMIT * 1024 * 1024,
+	}, config)
+}
+
+func TestUniqueEventTypes(t *testing.T) {
+	events := []types.Event{{Type: "foo"}, {Type: "bar"}, {Type: "foo"}}
+
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	err := vm.checkResponse(checksum, nil, nil, events)
+	require.NoError(t, err)
+
+	vm = withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		UniqueEventTypes:  true,
+	})
+	checksum = createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	err = vm.checkResponse(checksum, nil, nil, events)
+	require.ErrorIs(t, err, types.ErrDuplicateEventType)
+	err = vm.checkResponse(checksum, nil, nil, events[:2])
+	require.NoError(t, err)
+}
+
+func TestExecuteWithOutputCaptureInputs(t *testing.T) {
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		CaptureInputs:     true,
+	})
+	checksum := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	_, _, err := vm.Instantiate(checksum, env, info, []byte(`{}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	msg := []byte(`{"mirror_env": {}}`)
+	output, err := vm.ExecuteWithOutput(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.NotNil(t, output.RawInputs)
+	require.Equal(t, msg, output.RawInputs.Msg)
+	// the contract mirrors the env it received
+	require.Equal(t, output.Response.Data, output.RawInputs.Env)
+	expectedInfo, err := json.Marshal(info)
+	require.NoError(t, err)
+	require.Equal(t, expectedInfo, output.RawInputs.Info)
+}
+
+func TestCacheSoftLimit(t *testing.T) {
+	type call struct{ used, capacity uint64 }
+	var calls []call
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		// a single module exceeds 0.1% of the cache
+		CacheSoftLimitRatio: 0.001,
+		OnCacheSoftLimit: func(used, capacity uint64) {
+			calls = append(calls, call{used, capacity})
+		},
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	require.Empty(t, calls)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	_, _, err = vm.Query(checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	require.Len(t, calls, 1)
+	require.Equal(t, uint64(TESTING_CACHE_SIZE*1024*1024), calls[0].capacity)
+	require.GreaterOrEqual(t, calls[0].used, uint64(TESTING_CACHE_SIZE*1024*1024/1000))
+
+	// the other entry points are checked as well
+	calls = nil
+	vm = withVMConfig(t, types.VMConfig{
+		SupportedFeatures:   TESTING_FEATURES,
+		MemoryLimit:         TESTING_MEMORY_LIMIT,
+		CacheSize:           TESTING_CACHE_SIZE,
+		CacheSoftLimitRatio: 0.001,
+		OnCacheSoftLimit: func(used, capacity uint64) {
+			calls = append(calls, call{used, capacity})
+		},
+	})
+	checksum = createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	sudoMsg := []byte(`{"steal_funds":{"recipient":"community-pool","amount":[{"amount":"700","denom":"gold"}]}}`)
+	_, _, err = vm.Sudo(checksum, env, sudoMsg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Len(t, calls, 1)
+}
+
+func TestCheckCacheCompatibility(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	require.NoError(t, vm.CheckCacheCompatibility())
+	require.NoError(t, vm.MigrateCache())
+
+	// simulate a cache created by another version
+	err := os.WriteFile(filepath.Join(vm.dataDir, api.CacheVersionFile), []byte("0.0.1"), 0o644)
+	require.NoError(t, err)
+	err = vm.CheckCacheCompatibility()
+	var mismatch types.ErrCacheVersionMismatch
+	require.ErrorAs(t, err, &mismatch)
+	version, err := LibwasmvmVersion()
+	require.NoError(t, err)
+	require.Equal(t, "0.0.1", mismatch.CacheVersion)
+	require.Equal(t, version, mismatch.LibraryVersion)
+
+	err = vm.MigrateCache()
+	require.NoError(t, err)
+	require.NoError(t, vm.CheckCacheCompatibility())
+	// the module was rebuilt
+	_, err = vm.CompiledModuleHash(checksum)
+	require.NoError(t, err)
+}
+
+func TestCheckCacheCompatibilityUnknownVersion(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	config := types.VMConfig{
+		DataDir:           tmpdir,
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+	}
+	vm, err := NewVMWithConfig(config)
+	require.NoError(t, err)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	vm.Cleanup()
+
+	// simulate a cache created by a version without version file, which is reopened by a VM
+	// whose memory checks reject hackatom's 17 pages
+	require.NoError(t, os.Remove(filepath.Join(tmpdir, api.CacheVersionFile)))
+	config.MaxMemoryPages = 16
+	vm, err = NewVMWithConfig(config)
+	require.NoError(t, err)
+	defer vm.Cleanup()
+	err = vm.CheckCacheCompatibility()
+	var mismatch types.ErrCacheVersionMismatch
+	require.ErrorAs(t, err, &mismatch)
+	require.Equal(t, types.UnknownCacheVersion, mismatch.CacheVersion)
+
+	// stored codes are rebuilt regardless of the memory checks
+	require.NoError(t, vm.MigrateCache())
+	require.NoError(t, vm.CheckCacheCompatibility())
+	_, err = vm.CompiledModuleHash(checksum)
+	require.NoError(t, err)
+}
+
+func TestMigrateCacheFailure(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	invalid, err := vm.StoreCodeUnchecked([]byte("not wasm"))
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(vm.dataDir, api.CacheVersionFile), []byte("0.0.1"), 0o644)
+	require.NoError(t, err)
+	err = vm.MigrateCache()
+	require.ErrorContains(t, err, fmt.Sprintf("rebuilding %X: ", []byte(invalid)))
+	require.NotContains(t, err.Error(), fmt.Sprintf("%X", []byte(checksum)))
+
+	// the cache is not marked as compatible, but the valid code was rebuilt
+	var mismatch types.ErrCacheVersionMismatch
+	require.ErrorAs(t, vm.CheckCacheCompatibility(), &mismatch)
+	require.Equal(t, "0.0.1", mismatch.CacheVersion)
+	_, err = vm.CompiledModuleHash(checksum)
+	require.NoError(t, err)
+}
+
+// chunkRecorder is a writer recording the sizes of the writes
+type chunkRecorder struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.sizes = append(c.sizes, len(p))
+	return c.Buffer.Write(p)
+}
+
+func TestGetCodeTo(t *testing.T) {
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		CodeChunkSize:     1024,
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	code, err := vm.GetCode(checksum)
+	require.NoError(t, err)
+
+	var out chunkRecorder
+	n, err := vm.GetCodeTo(checksum, &out)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(code)), n)
+	require.Equal(t, []byte(code), out.Bytes())
+	for _, size := range out.sizes {
+		require.LessOrEqual(t, size, 1024)
+	}
+
+	// unknown code
+	_, err = vm.GetCodeTo(make([]byte, 32), &out)
+	require.ErrorIs(t, err, types.ErrCodeNotFound)
+	_, err = vm.GetCodeTo(checksum[:31], &out)
+	require.EqualError(t, err, "invalid checksum length 31, expected 32")
+
+	// corrupted code is reported after it was written
+	path := filepath.Join(vm.dataDir, "state", "wasm", hex.EncodeToString(checksum))
+	require.NoError(t, os.WriteFile(path, []byte("corrupt"), 0o644))
+	out.Reset()
+	n, err = vm.GetCodeTo(checksum, &out)
+	require.ErrorIs(t, err, types.ErrChecksumMismatch)
+	require.Equal(t, int64(7), n)
+	require.Equal(t, "corrupt", out.String())
+
+	// the layout of caches created by other libwasmvm versions is unknown
+	err = os.WriteFile(filepath.Join(vm.dataDir, api.CacheVersionFile), []byte("0.0.1"), 0o644)
+	require.NoError(t, err)
+	_, err = vm.GetCodeTo(checksum, &out)
+	var mismatch types.ErrCacheVersionMismatch
+	require.ErrorAs(t, err, &mismatch)
+	require.Equal(t, "0.0.1", mismatch.CacheVersion)
+}
+
+func TestGetCodeErrors(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	// GetCode reports the errors of libwasmvm
+	_, err := vm.GetCode(make([]byte, 32))
+	require.ErrorContains(t, err, "Error opening Wasm file for reading")
+	path := filepath.Join(vm.dataDir, "state", "wasm", hex.EncodeToString(checksum))
+	require.NoError(t, os.WriteFile(path, []byte("corrupt"), 0o644))
+	_, err = vm.GetCode(checksum)
+	require.ErrorContains(t, err, "Hash doesn't match stored data")
+}
+
+func TestGasScheduleFingerprint(t *testing.T) {
+	config := types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		EntryPointGas:     types.EntryPointGas{Execute: 1000},
+	}
+	fingerprint1, err := withVMConfig(t, config).GasScheduleFingerprint()
+	require.NoError(t, err)
+	require.Len(t, fingerprint1, 32)
+	fingerprint2, err := withVMConfig(t, config).GasScheduleFingerprint()
+	require.NoError(t, err)
+	require.Equal(t, fingerprint1, fingerprint2)
+
+	config.EntryPointGas.Execute = 1001
+	changed, err := withVMConfig(t, config).GasScheduleFingerprint()
+	require.NoError(t, err)
+	require.NotEqual(t, fingerprint1, changed)
+
+	config.EntryPointGas.Execute = 1000
+	config.IteratorGasCosts = types.IteratorGasCosts{Scan: 1, Next: 2}
+	changed, err = withVMConfig(t, config).GasScheduleFingerprint()
+	require.NoError(t, err)
+	require.NotEqual(t, fingerprint1, changed)
+}
+
+func TestGetCodes(t *testing.T) {
+	vm := withVM(t)
+	checksums, err := vm.GetCodes()
+	require.NoError(t, err)
+	require.Empty(t, checksums)
+
+	hackatom := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	cyberpunk := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+	reflect := createTestContract(t, vm, REFLECT_TEST_CONTRACT)
+	expected := []Checksum{hackatom, cyberpunk, reflect}
+	sort.Slice(expected, func(i, j int) bool {
+		return bytes.Compare(expected[i], expected[j]) < 0
+	})
+
+	checksums, err = vm.GetCodes()
+	require.NoError(t, err)
+	require.Equal(t, expected, checksums)
+}
+
+func TestCreateChecksum(t *testing.T) {
+	vm := withVM(t)
+	for _, path := range []string{HACKATOM_TEST_CONTRACT, CYBERPUNK_TEST_CONTRACT} {
+		wasm, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		checksum := CreateChecksum(wasm)
+
+		// same as computed by libwasmvm
+		created, err := vm.Create(wasm)
+		require.NoError(t, err)
+		require.Equal(t, created, checksum)
+
+		require.NoError(t, VerifyChecksum(wasm, checksum))
+		err = VerifyChecksum(wasm[1:], checksum)
+		require.ErrorIs(t, err, types.ErrChecksumMismatch)
+		require.ErrorContains(t, err, fmt.Sprintf("expected %X", []byte(checksum)))
+	}
+
+	// the checksum of empty code is defined, even though it cannot be stored
+	require.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(CreateChecksum(nil)))
+}
+
+func TestInstantiate2Address(t *testing.T) {
+	// test vector of cosmwasm-std
+	checksum, err := hex.DecodeString("13a1fc994cc6d1c81b746ee0c0ff6f90043875e0bf1d9be6b7d779fc978dc2a5")
+	require.NoError(t, err)
+	creator, err := hex.DecodeString("9999999999aaaaaaaaaabbbbbbbbbbcccccccccc")
+	require.NoError(t, err)
+	addr := Instantiate2Address(checksum, creator, []byte("a"), nil)
+	require.Equal(t, "5e865d3e45ad3e961f77fd77d46543417ced44d924dc3e079b5415ff6775f847", hex.EncodeToString(addr))
+
+	require.NotEqual(t, addr, Instantiate2Address(checksum, creator, []byte("b"), nil))
+	require.NotEqual(t, addr, Instantiate2Address(checksum, creator, []byte("a"), []byte("{}")))
+}
+
+func TestInstantiate2(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	// hex encoded addresses, as the mock API cannot humanize derived addresses
+	goapi := api.GoAPI{
+		HumanAddress: func(canon []byte) (string, uint64, error) {
+			return hex.EncodeToString(canon), 0, nil
+		},
+		CanonicalAddress: func(human string) ([]byte, uint64, error) {
+			return []byte(human), 0, nil
+		},
+	}
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{}`)
+
+	addr1, _, _, err := vm.Instantiate2(checksum, env, info, msg, []byte("salt"), store, goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(Instantiate2Address(checksum, []byte("creator"), []byte("salt"), nil)), addr1)
+
+	// same code, creator and salt collide
+	addr2, _, _, err := vm.Instantiate2(checksum, env, info, msg, []byte("salt"), store, goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, addr1, addr2)
+
+	// a different salt or creator gives a different address
+	addr3, _, _, err := vm.Instantiate2(checksum, env, info, msg, []byte("pepper"), store, goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.NotEqual(t, addr1, addr3)
+	addr4, _, _, err := vm.Instantiate2(checksum, env, api.MockInfo("other", nil), msg, []byte("salt"), store, goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.NotEqual(t, addr1, addr4)
+
+	_, _, _, err = vm.Instantiate2(checksum, env, info, msg, nil, store, goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorContains(t, err, "salt must be between 1 and 64 bytes")
+}
+
+func TestStoreCodeUnchecked(t *testing.T) {
+	wasm, err := ioutil.ReadFile(HACKATOM_TEST_CONTRACT)
+	require.NoError(t, err)
+	expected := sha256.Sum256(wasm)
+
+	vm := withVM(t)
+	checksum, err := vm.StoreCodeUnchecked(wasm)
+	require.NoError(t, err)
+	require.Equal(t, Checksum(expected[:]), checksum)
+
+	code, err := vm.GetCode(checksum)
+	require.NoError(t, err)
+	require.Equal(t, WasmCode(wasm), code)
+
+	// the code is compiled on first use
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err = vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	// storing the same code with validation yields the same checksum
+	checksum2, err := vm.Create(wasm)
+	require.NoError(t, err)
+	require.Equal(t, checksum, checksum2)
+
+	// invalid code is not rejected
+	_, err = vm.Create([]byte("not wasm"))
+	require.Error(t, err)
+	invalid, err := vm.StoreCodeUnchecked([]byte("not wasm"))
+	require.NoError(t, err)
+
+	// only the code files are left in the code directory
+	entries, err := os.ReadDir(filepath.Join(vm.dataDir, "state", "wasm"))
+	require.NoError(t, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	require.ElementsMatch(t, []string{hex.EncodeToString(checksum), hex.EncodeToString(invalid)}, names)
+
+	// the layout of caches created by other libwasmvm versions is unknown
+	err = os.WriteFile(filepath.Join(vm.dataDir, api.CacheVersionFile), []byte("0.0.1"), 0o644)
+	require.NoError(t, err)
+	_, err = vm.StoreCodeUnchecked(wasm)
+	var mismatch types.ErrCacheVersionMismatch
+	require.ErrorAs(t, err, &mismatch)
+}
+
+func TestNewVMWithFeatures(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	vm, err := NewVMWithFeatures(tmpdir, []string{"iterator", "staking", "stargate"}, TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.NoError(t, err)
+	require.Equal(t, []string{"iterator", "staking", "stargate"}, vm.supportedFeatures)
+	createTestContract(t, vm, IBC_TEST_CONTRACT)
+	vm.Cleanup()
+
+	// unknown features fail at construction
+	_, err = NewVMWithFeatures(tmpdir, []string{"iterator", "stargaze"}, TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.ErrorIs(t, err, types.ErrUnknownCapability)
+
+	// the string based constructor does not restrict the features to the known ones
+	vm, err = NewVM(tmpdir, "iterator,staking,stargate,chain_specific", TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.NoError(t, err)
+	require.Equal(t, []string{"iterator", "staking", "stargate", "chain_specific"}, vm.supportedFeatures)
+	createTestContract(t, vm, IBC_TEST_CONTRACT)
+	vm.Cleanup()
+}
+
+func TestGrantCapability(t *testing.T) {
+	wasm, err := ioutil.ReadFile(IBC_TEST_CONTRACT)
+	require.NoError(t, err)
+	hash := sha256.Sum256(wasm)
+	checksum := Checksum(hash[:])
+
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: "iterator",
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+	})
+
+	// stargate is not enabled globally
+	_, err = vm.Create(wasm)
+	require.ErrorContains(t, err, "requires unavailable capabilities")
+
+	// granting an unrelated capability does not help
+	vm.GrantCapability(checksum, "staking")
+	_, err = vm.Create(wasm)
+	require.ErrorContains(t, err, "requires unavailable capabilities")
+
+	// grants of other contracts do not help
+	vm.GrantCapability(Checksum("other"), "stargate")
+	_, err = vm.Create(wasm)
+	require.ErrorContains(t, err, "requires unavailable capabilities")
+
+	vm.GrantCapability(checksum, "stargate")
+	created, err := vm.Create(wasm)
+	require.NoError(t, err)
+	require.Equal(t, checksum, created)
+
+	// the contract is usable
+	reflectID := uint64(101)
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(fmt.Sprintf(`{"reflect_code_id": %d}`, reflectID))
+	_, _, err = vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	// granted code is still compiled and checked
+	invalid := api.MinimalFloatContract("stargate")
+	vm.GrantCapability(CreateChecksum(invalid), "stargate")
+	_, err = vm.Create(invalid)
+	require.ErrorContains(t, err, "Float operator detected")
+	_, err = vm.GetCode(CreateChecksum(invalid))
+	require.Error(t, err)
+}
+
+func TestRemoveCode(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	name := hex.EncodeToString(checksum)
+	modules, err := filepath.Glob(filepath.Join(vm.dataDir, "cache", "modules", "*", name))
+	require.NoError(t, err)
+	require.NotEmpty(t, modules)
+
+	err = vm.Pin(checksum)
+	require.NoError(t, err)
+	err = vm.RemoveCode(checksum)
+	require.ErrorIs(t, err, types.ErrCodePinned)
+	err = vm.Unpin(checksum)
+	require.NoError(t, err)
+
+	// per checksum state of the VM is dropped as well
+	vm.GrantCapability(checksum, "cosmwasm_1_1")
+	vm.replyHandlers.Store(string(checksum), true)
+	err = vm.RemoveCode(checksum)
+	require.NoError(t, err)
+	require.NotContains(t, vm.capabilityGrants, string(checksum))
+	_, ok := vm.replyHandlers.Load(string(checksum))
+	require.False(t, ok)
+	checksums, err := vm.GetCodes()
+	require.NoError(t, err)
+	require.Empty(t, checksums)
+	modules, err = filepath.Glob(filepath.Join(vm.dataDir, "cache", "modules", "*", name))
+	require.NoError(t, err)
+	require.Empty(t, modules)
+	_, err = vm.GetCode(checksum)
+	require.Error(t, err)
+
+	err = vm.RemoveCode(checksum)
+	require.ErrorIs(t, err, types.ErrCodeNotFound)
+
+	// invalid checksums do not touch the cache directory
+	err = vm.RemoveCode(nil)
+	require.EqualError(t, err, "invalid checksum length 0, expected 32")
+	err = vm.RemoveCode(checksum[:31])
+	require.EqualError(t, err, "invalid checksum length 31, expected 32")
+	require.DirExists(t, filepath.Join(vm.dataDir, "state", "wasm"))
+
+	// the code can be stored again
+	_ = createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	_, err = vm.GetCode(checksum)
+	require.NoError(t, err)
+}
+
+func TestRemoveCodeMemoryCache(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	vm, err := NewVM(tmpdir, TESTING_FEATURES, TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.NoError(t, err)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	instantiate := func(vm *VM) error {
+		gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+		store := api.NewLookup(gasMeter)
+		_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+		return err
+	}
+
+	// load the module into the memory cache
+	require.NoError(t, instantiate(vm))
+	metrics, err := vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), metrics.ElementsMemoryCache)
+
+	// the module stays in the memory cache and the code remains executable through this VM
+	require.NoError(t, vm.RemoveCode(checksum))
+	metrics, err = vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), metrics.ElementsMemoryCache)
+	require.NoError(t, instantiate(vm))
+	vm.Cleanup()
+
+	// until the VM is restarted
+	vm, err = NewVM(tmpdir, TESTING_FEATURES, TESTING_MEMORY_LIMIT, TESTING_PRINT_DEBUG, TESTING_CACHE_SIZE)
+	require.NoError(t, err)
+	defer vm.Cleanup()
+	require.Error(t, instantiate(vm))
+	metrics, err = vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), metrics.ElementsMemoryCache)
+}
+
+func TestExecuteWithOutputColdCallDuration(t *testing.T) {
+	// without memory cache every call loads the module from the file system cache
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         0,
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	// loading from the file system cache does not compile
+	output, err := vm.ExecuteWithOutput(checksum, env, api.MockInfo("fred", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Zero(t, output.ColdCallDuration)
+
+	// without the file system cache the module is compiled. release queries the balance of the contract.
+	modules, err := filepath.Glob(filepath.Join(vm.dataDir, "cache", "modules", "*", "*"))
+	require.NoError(t, err)
+	require.NotEmpty(t, modules)
+	for _, module := range modules {
+		require.NoError(t, os.Remove(module))
+	}
+	output, err = vm.ExecuteWithOutput(checksum, env, api.MockInfo("fred", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.NotZero(t, output.HostCallCounts[HostCallQuery])
+	require.NotZero(t, output.ColdCallDuration)
+
+	// the compiled module was stored in the file system cache again
+	output, err = vm.ExecuteWithOutput(checksum, env, api.MockInfo("fred", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Zero(t, output.ColdCallDuration)
+}
+
+// callCountStore records the active call count of the VM on every read
+type callCountStore struct {
+	*api.Lookup
+	vm     *VM
+	counts []uint64
+}
+
+func (s *callCountStore) Get(key []byte) []byte {
+	count, _ := s.vm.ActiveCallCount()
+	s.counts = append(s.counts, count)
+	return s.Lookup.Get(key)
+}
+
+func TestActiveCallCount(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	count, err := vm.ActiveCallCount()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), count)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := &callCountStore{Lookup: api.NewLookup(gasMeter), vm: vm}
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err = vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	_, _, err = vm.Execute(checksum, env, api.MockInfo("fred", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	// failing calls are counted as well
+	_, _, err = vm.Execute(checksum, env, api.MockInfo("mallory", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.Error(t, err)
+
+	// one call was active during each read
+	require.NotEmpty(t, store.counts)
+	for _, count := range store.counts {
+		require.Equal(t, uint64(1), count)
+	}
+	count, err = vm.ActiveCallCount()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), count)
+}
+
+func TestAcceptsExecuteVariant(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	_, err := vm.AcceptsExecuteVariant(checksum, "release")
+	require.ErrorIs(t, err, types.ErrNoVariantInfo)
+
+	// hackatom with its ExecuteMsg schema (shortened) injected as a custom section, like a chain would do
+	wasm, err := ioutil.ReadFile(HACKATOM_TEST_CONTRACT)
+	require.NoError(t, err)
+	name := "cw_execute_schema"
+	schema := `{"oneOf":[{"required":["release"]},{"required":["cpu_loop"]}]}`
+	payload := append([]byte{byte(len(name))}, name...)
+	payload = append(payload, schema...)
+	wasm = append(wasm, 0x00, byte(len(payload)))
+	wasm = append(wasm, payload...)
+	checksum, err = vm.Create(wasm)
+	require.NoError(t, err)
+
+	ok, err := vm.AcceptsExecuteVariant(checksum, "release")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = vm.AcceptsExecuteVariant(checksum, "Raw")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestDefaultGasLimit(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+
+	// no default
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, 0, deserCost)
+	require.ErrorIs(t, err, types.OutOfGasError{})
+
+	vm.SetDefaultGasLimit(TESTING_GAS_LIMIT)
+	_, _, err = vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, 0, deserCost)
+	require.NoError(t, err)
+	_, gasUsed, err := vm.Execute(checksum, env, api.MockInfo("fred", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, 0, deserCost)
+	require.NoError(t, err)
+	require.NotZero(t, gasUsed)
+
+	// an explicit limit takes precedence
+	_, _, err = vm.Execute(checksum, env, api.MockInfo("fred", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, 1000, deserCost)
+	require.ErrorIs(t, err, types.OutOfGasError{})
+}
+
+func TestCanonicalEvents(t *testing.T) {
+	build := func() ([]types.EventAttribute, []types.Event) {
+		attributes := []types.EventAttribute{{Key: "b", Value: "1"}, {Key: "a", Value: "1"}}
+		events := []types.Event{
+			{Type: "foo", Attributes: types.EventAttributes{{Key: "y", Value: "1"}, {Key: "x", Value: "1"}}},
+			{Type: "bar"},
+		}
+		return attributes, events
+	}
+
+	// order is preserved by default
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	attributes, events := build()
+	err := vm.checkResponse(checksum, nil, attributes, events)
+	require.NoError(t, err)
+	require.Equal(t, "b", attributes[0].Key)
+	require.Equal(t, "foo", events[0].Type)
+
+	vm = withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		CanonicalEvents:   true,
+	})
+	checksum = createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	attributes, events = build()
+	err = vm.checkResponse(checksum, nil, attributes, events)
+	require.NoError(t, err)
+	require.Equal(t, []types.EventAttribute{{Key: "a", Value: "1"}, {Key: "b", Value: "1"}}, attributes)
+	require.Equal(t, []types.Event{
+		{Type: "bar"},
+		{Type: "foo", Attributes: types.EventAttributes{{Key: "x", Value: "1"}, {Key: "y", Value: "1"}}},
+	}, events)
+}
+
+func TestStrictSubMessages(t *testing.T) {
+	gasLimit := uint64(5000)
+	msgs := []types.SubMsg{{ID: 1, ReplyOn: types.ReplyNever, GasLimit: &gasLimit}}
+
+	// accepted by default
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	err := vm.checkResponse(checksum, msgs, nil, nil)
+	require.NoError(t, err)
+
+	vm = withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		StrictSubMessages: true,
+	})
+	checksum = createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	err = vm.checkResponse(checksum, msgs, nil, nil)
+	require.ErrorIs(t, err, types.ErrSuspiciousSubMsg)
+	err = vm.checkResponse(checksum, []types.SubMsg{{ID: 1, ReplyOn: types.ReplyNever}}, nil, nil)
+	require.NoError(t, err)
+}
+
+func TestExecuteWithOutputDeserializationGas(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, CYBERPUNK_TEST_CONTRACT)
+
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"mirror_env": {}}`)
+
+	free := types.UFraction{Numerator: 0, Denominator: 1}
+	output, err := vm.ExecuteWithOutput(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, free)
+	require.NoError(t, err)
+	require.Zero(t, output.DeserializationGas)
+	gasWithoutDeser := output.GasUsed
+
+	// one gas per byte gives the size of the result
+	perByte := types.UFraction{Numerator: 1, Denominator: 1}
+	output, err = vm.ExecuteWithOutput(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, perByte)
+	require.NoError(t, err)
+	size := int(output.DeserializationGas)
+	require.NotZero(t, size)
+	require.Equal(t, gasWithoutDeser+output.DeserializationGas, output.GasUsed)
+
+	deserCost := types.UFraction{Numerator: 3, Denominator: 2}
+	output, err = vm.ExecuteWithOutput(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, deserCost.DeserializationGas(size), output.DeserializationGas)
+	require.Equal(t, gasWithoutDeser+output.DeserializationGas, output.GasUsed)
+}
+
+func TestQueryWithOutputAccessedKeys(t *testing.T) {
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		TrackQueryReads:   true,
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	output, err := vm.QueryWithOutput(checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, `{"verifier":"fred"}`, string(output.Data))
+	require.NotZero(t, output.GasUsed)
+	require.Equal(t, [][]byte{[]byte("config")}, output.AccessedKeys)
+
+	// not tracked by default
+	other := withVM(t)
+	checksum = createTestContract(t, other, HACKATOM_TEST_CONTRACT)
+	output, err = other.QueryWithOutput(checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Nil(t, output.AccessedKeys)
+}
+
+func TestQueryConcurrent(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	require.NoError(t, vm.Pin(checksum))
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	balance := types.Coins{types.NewCoin(250, "ATOM")}
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, balance)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	const workers, queriesPerWorker = 16, 25
+	errs := make(chan error, workers*queriesPerWorker)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < queriesPerWorker; i++ {
+				meter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+				workerStore := store.WithGasMeter(meter)
+				workerQuerier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, balance)
+				// mix queries with results of different sizes to detect memory shared between instances
+				query, expected := []byte(`{"verifier":{}}`), `{"verifier":"fred"}`
+				if (w+i)%2 == 1 {
+					query = []byte(fmt.Sprintf(`{"other_balance":{"address":"%s"}}`, api.MOCK_CONTRACT_ADDR))
+					expected = `{"amount":[{"denom":"ATOM","amount":"250"}]}`
+				}
+				data, _, err := vm.Query(checksum, env, query, workerStore, *goapi, workerQuerier, meter, TESTING_GAS_LIMIT, deserCost)
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if string(data) != expected {
+					errs <- fmt.Errorf("unexpected query result %s", data)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	count, err := vm.ActiveCallCount()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), count)
+}
+
+func TestExportState(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	store.Set([]byte("b"), []byte("2"))
+	store.Set([]byte("a"), []byte("1"))
+
+	keys := []string{}
+	err = vm.ExportState(store, nil, func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "config"}, keys)
+
+	// the export stops at the first error
+	stop := errors.New("stop")
+	keys = []string{}
+	err = vm.ExportState(store, []byte("con"), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, []string{"config"}, keys)
+}
+
+func TestExecuteRaw(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	balance := types.Coins{types.NewCoin(250, "ATOM")}
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, balance)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	info = api.MockInfo("fred", nil)
+	res, raw, gasUsed, err := vm.ExecuteRaw(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.NotZero(t, gasUsed)
+	require.NotEmpty(t, res.Attributes)
+
+	// the raw bytes decode to the same response
+	var result types.ContractResult
+	require.NoError(t, json.Unmarshal(raw.Result, &result))
+	require.Equal(t, res, result.Ok)
+	var attributes []types.EventAttribute
+	require.NoError(t, json.Unmarshal(raw.Attributes, &attributes))
+	require.Equal(t, res.Attributes, attributes)
+	var events []types.Event
+	require.NoError(t, json.Unmarshal(raw.Events, &events))
+	require.Equal(t, res.Events, events)
+
+	// contract errors come with the raw result
+	info = api.MockInfo("bob", nil)
+	res, raw, _, err = vm.ExecuteRaw(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorContains(t, err, "Unauthorized")
+	require.Nil(t, res)
+	require.Contains(t, string(raw.Result), "Unauthorized")
+	require.Nil(t, raw.Attributes)
+	require.Nil(t, raw.Events)
+}
+
+func TestAuditSink(t *testing.T) {
+	var entries []types.AuditEntry
+	vm := withVMConfig(t, types.VMConfig{
+		SupportedFeatures: TESTING_FEATURES,
+		MemoryLimit:       TESTING_MEMORY_LIMIT,
+		CacheSize:         TESTING_CACHE_SIZE,
+		AuditSink: func(entry types.AuditEntry) {
+			entries = append(entries, entry)
+		},
+	})
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+	require.Empty(t, entries)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	balance := types.Coins{types.NewCoin(250, "ATOM")}
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, balance)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, instantiateGas, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	_, _, err = vm.Execute(checksum, env, api.MockInfo("bob", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.ErrorContains(t, err, "Unauthorized")
+	_, executeGas, err := vm.Execute(checksum, env, api.MockInfo("fred", nil), []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	_, queryGas, err := vm.Query(checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	require.Len(t, entries, 4)
+	require.Equal(t, types.AuditEntry{Checksum: checksum, EntryPoint: "instantiate", Sender: "creator", GasUsed: instantiateGas}, entries[0])
+	require.Equal(t, "execute", entries[1].EntryPoint)
+	require.Equal(t, "bob", entries[1].Sender)
+	require.False(t, entries[1].Success())
+	require.Contains(t, entries[1].Err, "Unauthorized")
+	require.NotZero(t, entries[1].GasUsed)
+	require.Equal(t, types.AuditEntry{Checksum: checksum, EntryPoint: "execute", Sender: "fred", GasUsed: executeGas}, entries[2])
+	require.True(t, entries[2].Success())
+	require.Equal(t, types.AuditEntry{Checksum: checksum, EntryPoint: "query", GasUsed: queryGas}, entries[3])
+}
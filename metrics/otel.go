@@ -0,0 +1,85 @@
+// Package metrics exports the cache metrics of a VM to monitoring systems.
+package metrics
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/metric"
+
+	cosmwasm "github.com/Finschia/wasmvm"
+)
+
+// Names of the OpenTelemetry instruments registered by NewOTelMeter
+const (
+	HitsPinnedMemoryCacheName     = "wasmvm.cache.hits.pinned_memory"
+	HitsMemoryCacheName           = "wasmvm.cache.hits.memory"
+	HitsFsCacheName               = "wasmvm.cache.hits.fs"
+	MissesName                    = "wasmvm.cache.misses"
+	ElementsPinnedMemoryCacheName = "wasmvm.cache.elements.pinned_memory"
+	ElementsMemoryCacheName       = "wasmvm.cache.elements.memory"
+	SizePinnedMemoryCacheName     = "wasmvm.cache.size.pinned_memory"
+	SizeMemoryCacheName           = "wasmvm.cache.size.memory"
+)
+
+// NewOTelMeter registers observable instruments for all fields of types.Metrics with meter.
+// The hit and miss counts are counters, the number of elements and the sizes are gauges.
+// The values are read from vm.GetMetrics whenever the meter is collected.
+// Unregister the returned registration before the VM is cleaned up.
+func NewOTelMeter(vm *cosmwasm.VM, meter metric.Meter) (metric.Registration, error) {
+	hitsPinned, err := meter.Int64ObservableCounter(HitsPinnedMemoryCacheName,
+		metric.WithDescription("Number of cache hits in the pinned memory cache"))
+	if err != nil {
+		return nil, err
+	}
+	hitsMemory, err := meter.Int64ObservableCounter(HitsMemoryCacheName,
+		metric.WithDescription("Number of cache hits in the memory cache"))
+	if err != nil {
+		return nil, err
+	}
+	hitsFs, err := meter.Int64ObservableCounter(HitsFsCacheName,
+		metric.WithDescription("Number of cache hits in the file system cache"))
+	if err != nil {
+		return nil, err
+	}
+	misses, err := meter.Int64ObservableCounter(MissesName,
+		metric.WithDescription("Number of cache misses, which require compiling the Wasm code"))
+	if err != nil {
+		return nil, err
+	}
+	elementsPinned, err := meter.Int64ObservableGauge(ElementsPinnedMemoryCacheName,
+		metric.WithDescription("Number of modules in the pinned memory cache"))
+	if err != nil {
+		return nil, err
+	}
+	elementsMemory, err := meter.Int64ObservableGauge(ElementsMemoryCacheName,
+		metric.WithDescription("Number of modules in the memory cache"))
+	if err != nil {
+		return nil, err
+	}
+	sizePinned, err := meter.Int64ObservableGauge(SizePinnedMemoryCacheName,
+		metric.WithDescription("Cumulative size of the modules in the pinned memory cache"), metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+	sizeMemory, err := meter.Int64ObservableGauge(SizeMemoryCacheName,
+		metric.WithDescription("Cumulative size of the modules in the memory cache"), metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		m, err := vm.GetMetrics()
+		if err != nil {
+			return err
+		}
+		o.ObserveInt64(hitsPinned, int64(m.HitsPinnedMemoryCache))
+		o.ObserveInt64(hitsMemory, int64(m.HitsMemoryCache))
+		o.ObserveInt64(hitsFs, int64(m.HitsFsCache))
+		o.ObserveInt64(misses, int64(m.Misses))
+		o.ObserveInt64(elementsPinned, int64(m.ElementsPinnedMemoryCache))
+		o.ObserveInt64(elementsMemory, int64(m.ElementsMemoryCache))
+		o.ObserveInt64(sizePinned, int64(m.SizePinnedMemoryCache))
+		o.ObserveInt64(sizeMemory, int64(m.SizeMemoryCache))
+		return nil
+	}, hitsPinned, hitsMemory, hitsFs, misses, elementsPinned, elementsMemory, sizePinned, sizeMemory)
+}
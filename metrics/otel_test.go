@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	cosmwasm "github.com/Finschia/wasmvm"
+)
+
+// collect reads all int64 data points from reader by instrument name
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]int64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	values := make(map[string]int64)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				require.Len(t, data.DataPoints, 1)
+				require.True(t, data.IsMonotonic)
+				values[m.Name] = data.DataPoints[0].Value
+			case metricdata.Gauge[int64]:
+				require.Len(t, data.DataPoints, 1)
+				values[m.Name] = data.DataPoints[0].Value
+			default:
+				t.Fatalf("unexpected data type %T of %s", m.Data, m.Name)
+			}
+		}
+	}
+	return values
+}
+
+func TestNewOTelMeter(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "wasmvm-testing")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	vm, err := cosmwasm.NewVM(tmpdir, "iterator,staking,stargate", 32, false, 100)
+	require.NoError(t, err)
+	defer vm.Cleanup()
+
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	registration, err := NewOTelMeter(vm, provider.Meter("wasmvm"))
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int64{
+		HitsPinnedMemoryCacheName:     0,
+		HitsMemoryCacheName:           0,
+		HitsFsCacheName:               0,
+		MissesName:                    0,
+		ElementsPinnedMemoryCacheName: 0,
+		ElementsMemoryCacheName:       0,
+		SizePinnedMemoryCacheName:     0,
+		SizeMemoryCacheName:           0,
+	}, collect(t, reader))
+
+	wasm, err := ioutil.ReadFile("../testdata/hackatom.wasm")
+	require.NoError(t, err)
+	checksum, err := vm.Create(wasm)
+	require.NoError(t, err)
+	require.NoError(t, vm.Pin(checksum))
+
+	metrics, err := vm.GetMetrics()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), metrics.ElementsPinnedMemoryCache)
+	require.Equal(t, map[string]int64{
+		HitsPinnedMemoryCacheName:     int64(metrics.HitsPinnedMemoryCache),
+		HitsMemoryCacheName:           int64(metrics.HitsMemoryCache),
+		HitsFsCacheName:               int64(metrics.HitsFsCache),
+		MissesName:                    int64(metrics.Misses),
+		ElementsPinnedMemoryCacheName: 1,
+		ElementsMemoryCacheName:       int64(metrics.ElementsMemoryCache),
+		SizePinnedMemoryCacheName:     int64(metrics.SizePinnedMemoryCache),
+		SizeMemoryCacheName:           int64(metrics.SizeMemoryCache),
+	}, collect(t, reader))
+
+	require.NoError(t, registration.Unregister())
+}
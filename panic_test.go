@@ -0,0 +1,116 @@
+package cosmwasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+// abortingQueryContract returns a minimal contract whose query entry point calls abort with
+// the query message, just like a panicking contract does.
+func abortingQueryContract() []byte {
+	uleb := func(v uint32) []byte {
+		out := []byte{}
+		for {
+			b := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 {
+				return append(out, b)
+			}
+			out = append(out, b|0x80)
+		}
+	}
+	vec := func(items ...[]byte) []byte {
+		out := uleb(uint32(len(items)))
+		for _, item := range items {
+			out = append(out, item...)
+		}
+		return out
+	}
+	name := func(s string) []byte {
+		return append(uleb(uint32(len(s))), s...)
+	}
+	section := func(id byte, payload []byte) []byte {
+		return append(append([]byte{id}, uleb(uint32(len(payload)))...), payload...)
+	}
+	body := func(locals []byte, code ...byte) []byte {
+		b := append(locals, code...)
+		return append(uleb(uint32(len(b))), b...)
+	}
+	const i32 = 0x7f
+
+	wasm := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	wasm = append(wasm, section(1, vec(
+		[]byte{0x60, 0x01, i32, 0x00},                // 0: (i32) -> ()
+		[]byte{0x60, 0x00, 0x00},                     // 1: () -> ()
+		[]byte{0x60, 0x01, i32, 0x01, i32},           // 2: (i32) -> i32
+		[]byte{0x60, 0x02, i32, i32, 0x01, i32},      // 3: (i32, i32) -> i32
+		[]byte{0x60, 0x03, i32, i32, i32, 0x01, i32}, // 4: (i32, i32, i32) -> i32
+	))...)
+	wasm = append(wasm, section(2, vec(
+		append(append(name("env"), name("abort")...), 0x00, 0x00),
+	))...)
+	// allocate, deallocate, interface_version_8, query, instantiate
+	wasm = append(wasm, section(3, vec([]byte{2}, []byte{0}, []byte{1}, []byte{3}, []byte{4}))...)
+	// one page of memory without maximum
+	wasm = append(wasm, section(5, vec([]byte{0x00, 0x01}))...)
+	// mutable i32 global for the bump allocator, starting at 1024
+	wasm = append(wasm, section(6, vec([]byte{i32, 0x01, 0x41, 0x80, 0x08, 0x0b}))...)
+	wasm = append(wasm, section(7, vec(
+		append(name("memory"), 0x02, 0x00),
+		append(name("allocate"), 0x00, 0x01),
+		append(name("deallocate"), 0x00, 0x02),
+		append(name("interface_version_8"), 0x00, 0x03),
+		append(name("query"), 0x00, 0x04),
+		append(name("instantiate"), 0x00, 0x05),
+	))...)
+	wasm = append(wasm, section(10, vec(
+		// allocate(size): creates a Region {offset, capacity, length} followed by its data
+		body([]byte{0x01, 0x01, i32},
+			0x23, 0x00, 0x21, 0x01, // region = global
+			0x20, 0x01, 0x20, 0x01, 0x41, 0x0c, 0x6a, 0x36, 0x02, 0x00, // region.offset = region + 12
+			0x20, 0x01, 0x20, 0x00, 0x36, 0x02, 0x04, // region.capacity = size
+			0x20, 0x01, 0x41, 0x00, 0x36, 0x02, 0x08, // region.length = 0
+			// global = region + 12 + size, aligned to 4 bytes since the VM expects aligned Regions
+			0x20, 0x01, 0x41, 0x0f, 0x6a, 0x20, 0x00, 0x6a, 0x41, 0x7c, 0x71, 0x24, 0x00,
+			0x20, 0x01, 0x0b),
+		// deallocate(ptr)
+		body([]byte{0x00}, 0x0b),
+		// interface_version_8()
+		body([]byte{0x00}, 0x0b),
+		// query(env, msg): abort(msg)
+		body([]byte{0x00}, 0x20, 0x01, 0x10, 0x00, 0x41, 0x00, 0x0b),
+		// instantiate(env, info, msg): abort(msg)
+		body([]byte{0x00}, 0x20, 0x02, 0x10, 0x00, 0x41, 0x00, 0x0b),
+	))...)
+	return wasm
+}
+
+func TestQueryContractPanic(t *testing.T) {
+	vm := withVM(t)
+	checksum, err := vm.Create(abortingQueryContract())
+	require.NoError(t, err)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	store.Set([]byte("foo"), []byte("bar"))
+	before, err := store.Export()
+	require.NoError(t, err)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+	env := api.MockEnv()
+
+	_, gasUsed, err := vm.Query(checksum, env, []byte("panicked at 'boom'"), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	var panicErr types.ErrContractPanic
+	require.ErrorAs(t, err, &panicErr)
+	require.Contains(t, panicErr.Msg, "Aborted: panicked at 'boom'")
+	require.NotZero(t, gasUsed)
+
+	after, err := store.Export()
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
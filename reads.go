@@ -0,0 +1,74 @@
+package cosmwasm
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// readTracker records the store keys read during one contract call (see VMConfig.TrackQueryReads)
+type readTracker struct {
+	mutex sync.Mutex
+	keys  map[string]struct{}
+}
+
+func newReadTracker() *readTracker {
+	return &readTracker{keys: make(map[string]struct{})}
+}
+
+func (r *readTracker) add(key []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.keys[string(key)] = struct{}{}
+}
+
+// Keys returns the distinct keys read so far in ascending order
+func (r *readTracker) Keys() [][]byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	keys := make([][]byte, 0, len(r.keys))
+	for key := range r.keys {
+		keys = append(keys, []byte(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// wrap returns a version of the given store that records all keys read from it
+func (r *readTracker) wrap(store KVStore) KVStore {
+	return trackingStore{store, r}
+}
+
+type trackingStore struct {
+	KVStore
+	tracker *readTracker
+}
+
+func (s trackingStore) Get(key []byte) []byte {
+	s.tracker.add(key)
+	return s.KVStore.Get(key)
+}
+
+func (s trackingStore) Iterator(start, end []byte) dbm.Iterator {
+	return trackingIterator{s.KVStore.Iterator(start, end), s.tracker}
+}
+
+func (s trackingStore) ReverseIterator(start, end []byte) dbm.Iterator {
+	return trackingIterator{s.KVStore.ReverseIterator(start, end), s.tracker}
+}
+
+// trackingIterator records the keys returned by an iterator
+type trackingIterator struct {
+	dbm.Iterator
+	tracker *readTracker
+}
+
+func (i trackingIterator) Key() []byte {
+	key := i.Iterator.Key()
+	i.tracker.add(key)
+	return key
+}
@@ -0,0 +1,213 @@
+package cosmwasm
+
+import (
+	"bytes"
+	"sort"
+
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Finschia/wasmvm/types"
+)
+
+// The Simulate* methods work like the corresponding contract calls but discard all writes to the store,
+// such that callers can inspect the response (messages, events) a contract would return without any
+// side effects. Reads are served by the given store and see the writes made earlier in the same simulation.
+//
+// The returned gas used is the same as for the real call. Writes never reach the store, so the gas the
+// store would charge to the gas meter for them is not consumed: the gas meter only reflects the reads of a
+// simulation, and callers that use it as a gas estimate must add the cost of the writes themselves.
+
+// SimulateExecute works like Execute but discards all writes to the store
+func (vm *VM) SimulateExecute(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.Response, uint64, error) {
+	return vm.Execute(checksum, env, info, executeMsg, newOverlayStore(store), goapi, querier, gasMeter, gasLimit, deserCost)
+}
+
+// SimulateInstantiate works like Instantiate but discards all writes to the store
+func (vm *VM) SimulateInstantiate(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	initMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.Response, uint64, error) {
+	return vm.Instantiate(checksum, env, info, initMsg, newOverlayStore(store), goapi, querier, gasMeter, gasLimit, deserCost)
+}
+
+// overlayEntry is a write buffered by overlayStore
+type overlayEntry struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+// overlayStore is a KVStore that buffers all writes in memory and reads through to its parent
+type overlayStore struct {
+	parent KVStore
+	writes map[string]overlayEntry
+}
+
+var _ KVStore = &overlayStore{}
+
+func newOverlayStore(parent KVStore) *overlayStore {
+	return &overlayStore{
+		parent: parent,
+		writes: make(map[string]overlayEntry),
+	}
+}
+
+func (s *overlayStore) Get(key []byte) []byte {
+	if entry, ok := s.writes[string(key)]; ok {
+		if entry.deleted {
+			return nil
+		}
+		return entry.value
+	}
+	return s.parent.Get(key)
+}
+
+func (s *overlayStore) Set(key, value []byte) {
+	s.writes[string(key)] = overlayEntry{key: bytes.Clone(key), value: bytes.Clone(value)}
+}
+
+func (s *overlayStore) Delete(key []byte) {
+	s.writes[string(key)] = overlayEntry{key: bytes.Clone(key), deleted: true}
+}
+
+func (s *overlayStore) Iterator(start, end []byte) dbm.Iterator {
+	return newOverlayIterator(s.parent.Iterator(start, end), s.entries(start, end), true)
+}
+
+func (s *overlayStore) ReverseIterator(start, end []byte) dbm.Iterator {
+	return newOverlayIterator(s.parent.ReverseIterator(start, end), s.entries(start, end), false)
+}
+
+// entries returns the buffered writes in the range [start, end) sorted by key
+func (s *overlayStore) entries(start, end []byte) []overlayEntry {
+	var entries []overlayEntry
+	for _, entry := range s.writes {
+		if start != nil && bytes.Compare(entry.key, start) < 0 {
+			continue
+		}
+		if end != nil && bytes.Compare(entry.key, end) >= 0 {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	return entries
+}
+
+// overlayIterator merges an iterator of the parent store with a snapshot of the buffered writes
+type overlayIterator struct {
+	parent    dbm.Iterator
+	entries   []overlayEntry
+	pos       int
+	ascending bool
+}
+
+var _ dbm.Iterator = &overlayIterator{}
+
+func newOverlayIterator(parent dbm.Iterator, entries []overlayEntry, ascending bool) *overlayIterator {
+	if !ascending {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	it := &overlayIterator{parent: parent, entries: entries, ascending: ascending}
+	it.skip()
+	return it
+}
+
+// compare compares two keys in iteration order
+func (it *overlayIterator) compare(a, b []byte) int {
+	if it.ascending {
+		return bytes.Compare(a, b)
+	}
+	return bytes.Compare(b, a)
+}
+
+// skip moves past parent entries overwritten by a buffered write and past deleted entries
+func (it *overlayIterator) skip() {
+	for it.pos < len(it.entries) {
+		entry := it.entries[it.pos]
+		if it.parent.Valid() {
+			c := it.compare(it.parent.Key(), entry.key)
+			if c < 0 {
+				return
+			}
+			if c == 0 {
+				it.parent.Next()
+				continue
+			}
+		}
+		if !entry.deleted {
+			return
+		}
+		it.pos++
+	}
+}
+
+// useEntry returns true if the current item is a buffered write
+func (it *overlayIterator) useEntry() bool {
+	if it.pos >= len(it.entries) {
+		return false
+	}
+	return !it.parent.Valid() || it.compare(it.parent.Key(), it.entries[it.pos].key) > 0
+}
+
+func (it *overlayIterator) Domain() (start []byte, end []byte) {
+	return it.parent.Domain()
+}
+
+func (it *overlayIterator) Valid() bool {
+	return it.parent.Valid() || it.pos < len(it.entries)
+}
+
+func (it *overlayIterator) Next() {
+	if it.useEntry() {
+		it.pos++
+	} else {
+		it.parent.Next()
+	}
+	it.skip()
+}
+
+func (it *overlayIterator) Key() []byte {
+	if it.useEntry() {
+		return it.entries[it.pos].key
+	}
+	return it.parent.Key()
+}
+
+func (it *overlayIterator) Value() []byte {
+	if it.useEntry() {
+		return it.entries[it.pos].value
+	}
+	return it.parent.Value()
+}
+
+func (it *overlayIterator) Error() error {
+	return it.parent.Error()
+}
+
+func (it *overlayIterator) Close() error {
+	return it.parent.Close()
+}
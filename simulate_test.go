@@ -0,0 +1,144 @@
+package cosmwasm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+func TestOverlayStore(t *testing.T) {
+	parent := api.NewLookup(api.NewMockGasMeter(TESTING_GAS_LIMIT))
+	parent.Set([]byte("a"), []byte("1"))
+	parent.Set([]byte("b"), []byte("2"))
+	parent.Set([]byte("c"), []byte("3"))
+
+	store := newOverlayStore(parent)
+	store.Set([]byte("b"), []byte("20"))
+	store.Delete([]byte("c"))
+	store.Set([]byte("d"), []byte("4"))
+	store.Delete([]byte("e"))
+
+	require.Equal(t, []byte("1"), store.Get([]byte("a")))
+	require.Equal(t, []byte("20"), store.Get([]byte("b")))
+	require.Nil(t, store.Get([]byte("c")))
+	require.Equal(t, []byte("4"), store.Get([]byte("d")))
+
+	collect := func(ascending bool, start, end []byte) []string {
+		iter := store.Iterator(start, end)
+		if !ascending {
+			iter = store.ReverseIterator(start, end)
+		}
+		defer iter.Close()
+		var items []string
+		for ; iter.Valid(); iter.Next() {
+			items = append(items, string(iter.Key())+"="+string(iter.Value()))
+		}
+		return items
+	}
+	require.Equal(t, []string{"a=1", "b=20", "d=4"}, collect(true, nil, nil))
+	require.Equal(t, []string{"d=4", "b=20", "a=1"}, collect(false, nil, nil))
+	require.Equal(t, []string{"b=20"}, collect(true, []byte("b"), []byte("d")))
+	require.Equal(t, []string{"d=4", "b=20"}, collect(false, []byte("b"), nil))
+
+	// the parent is unchanged
+	require.Equal(t, []byte("2"), parent.Get([]byte("b")))
+	require.Equal(t, []byte("3"), parent.Get([]byte("c")))
+	require.Nil(t, parent.Get([]byte("d")))
+}
+
+func TestSimulateExecute(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	before, err := store.Export()
+	require.NoError(t, err)
+
+	info = api.MockInfo("fred", nil)
+	simulated, _, err := vm.SimulateExecute(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	after, err := store.Export()
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	res, _, err := vm.Execute(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Equal(t, res.Messages, simulated.Messages)
+	require.True(t, types.ResponsesEqual(res, simulated))
+}
+
+func TestSimulateInstantiate(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+
+	simulated, simulatedGas, err := vm.SimulateInstantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.NotZero(t, simulatedGas)
+	data, err := store.Export()
+	require.NoError(t, err)
+	require.Empty(t, data)
+	// nothing was stored, so the contract cannot be queried
+	_, _, err = vm.Query(checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.Error(t, err)
+
+	res, gas, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.True(t, types.ResponsesEqual(res, simulated))
+	require.Equal(t, gas, simulatedGas)
+}
+
+func TestSimulateInstantiateExcludesWriteGas(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+
+	simulatedMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	simulatedStore := api.NewLookup(simulatedMeter)
+	_, simulatedGas, err := vm.SimulateInstantiate(checksum, env, info, msg, simulatedStore, *goapi, querier, simulatedMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	_, gas, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	consumed := gasMeter.GasConsumed()
+	// each key was written once
+	var writes uint64
+	iter := store.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		writes++
+	}
+	require.NoError(t, iter.Close())
+	require.NotZero(t, writes)
+
+	// the gas used by the VM is the same, but the store did not charge the gas meter for the writes
+	require.Equal(t, gas, simulatedGas)
+	require.Equal(t, consumed-writes*api.SetPrice, simulatedMeter.GasConsumed())
+}
@@ -0,0 +1,86 @@
+package cosmwasm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Finschia/wasmvm/types"
+)
+
+// The *WithOptions methods support an execution timeout on top of gas metering. Like the
+// *WithContext methods, the timeout can only be enforced when the contract calls into the host
+// (storage, address conversion and queries), since libwasmvm cannot be interrupted from the outside.
+// The time is measured by the clock of the VM (see SetClock).
+
+// ExecuteWithOptions works like Execute but applies the given options
+func (vm *VM) ExecuteWithOptions(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+	opts types.ExecutionOptions,
+) (*types.Response, uint64, error) {
+	if opts.ExecutionTimeout == 0 {
+		return vm.Execute(checksum, env, info, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	}
+	ctx := deadlineContext{Context: context.Background(), deadline: vm.newDeadline(opts.ExecutionTimeout)}
+	res, gasUsed, err := vm.ExecuteWithContext(ctx, checksum, env, info, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return res, gasUsed, timeoutError(opts.ExecutionTimeout, err)
+}
+
+// QueryWithOptions works like Query but applies the given options
+func (vm *VM) QueryWithOptions(
+	checksum Checksum,
+	env types.Env,
+	queryMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+	opts types.ExecutionOptions,
+) ([]byte, uint64, error) {
+	if opts.ExecutionTimeout == 0 {
+		return vm.Query(checksum, env, queryMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	}
+	ctx := deadlineContext{Context: context.Background(), deadline: vm.newDeadline(opts.ExecutionTimeout)}
+	res, gasUsed, err := vm.QueryWithContext(ctx, checksum, env, queryMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+	return res, gasUsed, timeoutError(opts.ExecutionTimeout, err)
+}
+
+// deadlineContext is a context that is done once the deadline is exceeded.
+// Unlike context.WithTimeout it uses the clock of the VM. Done is not supported
+// since the deadline is only polled via Err.
+type deadlineContext struct {
+	context.Context
+	deadline deadline
+}
+
+func (c deadlineContext) Deadline() (time.Time, bool) {
+	return c.deadline.at, true
+}
+
+func (c deadlineContext) Err() error {
+	if c.deadline.Exceeded() {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// timeoutError replaces the error of a contract call that was aborted because of its execution timeout.
+// Errors of calls that failed for other reasons are kept, even if the deadline passed by now.
+func timeoutError(timeout time.Duration, err error) error {
+	// the *WithContext methods only wrap ctx.Err() for calls that were actually aborted
+	if errors.Is(err, context.DeadlineExceeded) {
+		return types.ExecutionTimeoutError{Timeout: timeout}
+	}
+	return err
+}
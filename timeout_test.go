@@ -0,0 +1,59 @@
+package cosmwasm
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+func TestExecuteWithOptions(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	// every reading of the clock advances it by one second
+	now := time.Unix(1_600_000_000, 0)
+	vm.SetClock(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(math.MaxUint64)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	// the timeout stops a contract that would otherwise run for a very long time
+	info = api.MockInfo("fred", nil)
+	opts := types.ExecutionOptions{ExecutionTimeout: 10 * time.Second}
+	_, gasUsed, err := vm.ExecuteWithOptions(checksum, env, info, []byte(`{"storage_loop":{}}`), store, *goapi, querier, gasMeter, math.MaxUint64/2, deserCost, opts)
+	require.Equal(t, types.ExecutionTimeoutError{Timeout: 10 * time.Second}, err)
+	require.NotZero(t, gasUsed)
+
+	// errors of calls that fail without calling into the host are kept even after the deadline passed
+	_, _, err = vm.ExecuteWithOptions(checksum, env, info, []byte(`{"unknown":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost, types.ExecutionOptions{ExecutionTimeout: time.Millisecond})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, types.ExecutionTimeoutError{Timeout: time.Millisecond})
+	require.ErrorContains(t, err, "unknown variant")
+
+	// queries
+	_, _, err = vm.QueryWithOptions(checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost, types.ExecutionOptions{ExecutionTimeout: time.Second / 2})
+	require.Equal(t, types.ExecutionTimeoutError{Timeout: time.Second / 2}, err)
+	qres, _, err := vm.QueryWithOptions(checksum, env, []byte(`{"verifier":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost, types.ExecutionOptions{ExecutionTimeout: time.Hour})
+	require.NoError(t, err)
+	require.Equal(t, `{"verifier":"fred"}`, string(qres))
+
+	// disabled by default
+	_, _, err = vm.ExecuteWithOptions(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost, types.ExecutionOptions{})
+	require.NoError(t, err)
+}
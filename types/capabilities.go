@@ -0,0 +1,38 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KnownCapabilities are the capabilities supported by this version of libwasmvm
+var KnownCapabilities = []string{"cosmwasm_1_1", "iterator", "staking", "stargate"}
+
+// ErrUnknownCapability is returned by ValidateCapabilities for capabilities not in KnownCapabilities
+var ErrUnknownCapability = errors.New("unknown capability")
+
+// ValidateCapabilities returns an error wrapping ErrUnknownCapability for the first of the given
+// capabilities that is not in KnownCapabilities
+func ValidateCapabilities(capabilities []string) error {
+	if missing, ok := CapabilitiesSatisfied(capabilities, KnownCapabilities); !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCapability, missing[0])
+	}
+	return nil
+}
+
+// CapabilitiesSatisfied checks if all required capabilities are contained in the enabled ones.
+// It returns the missing capabilities in the order of required, without duplicates.
+func CapabilitiesSatisfied(required, enabled []string) (missing []string, ok bool) {
+	available := make(map[string]bool, len(enabled))
+	for _, capability := range enabled {
+		available[capability] = true
+	}
+	for _, capability := range required {
+		if !available[capability] {
+			missing = append(missing, capability)
+			// report each capability only once
+			available[capability] = true
+		}
+	}
+	return missing, len(missing) == 0
+}
@@ -0,0 +1,37 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesSatisfied(t *testing.T) {
+	enabled := []string{"iterator", "staking", "stargate"}
+
+	missing, ok := CapabilitiesSatisfied([]string{"staking", "iterator"}, enabled)
+	assert.True(t, ok)
+	assert.Empty(t, missing)
+
+	missing, ok = CapabilitiesSatisfied([]string{"staking", "cosmwasm_1_1", "cosmwasm_1_1"}, enabled)
+	assert.False(t, ok)
+	assert.Equal(t, []string{"cosmwasm_1_1"}, missing)
+
+	missing, ok = CapabilitiesSatisfied(nil, enabled)
+	assert.True(t, ok)
+	assert.Empty(t, missing)
+
+	missing, ok = CapabilitiesSatisfied([]string{}, nil)
+	assert.True(t, ok)
+	assert.Empty(t, missing)
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	assert.NoError(t, ValidateCapabilities(nil))
+	assert.NoError(t, ValidateCapabilities([]string{"stargate", "iterator", "staking", "cosmwasm_1_1"}))
+
+	err := ValidateCapabilities([]string{"iterator", "stargaze", "foo"})
+	assert.ErrorIs(t, err, ErrUnknownCapability)
+	assert.EqualError(t, err, `unknown capability: "stargaze"`)
+	assert.ErrorIs(t, ValidateCapabilities([]string{""}), ErrUnknownCapability)
+}
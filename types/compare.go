@@ -0,0 +1,85 @@
+package types
+
+import (
+	"reflect"
+	"sort"
+)
+
+type compareConfig struct {
+	ignoreOrder  bool
+	ignoreGas    bool
+	ignoreEvents bool
+}
+
+// CompareOption configures ResponsesEqual
+type CompareOption func(*compareConfig)
+
+// IgnoreAttributeOrder compares the attributes of the response and of each event as sets
+func IgnoreAttributeOrder() CompareOption {
+	return func(c *compareConfig) { c.ignoreOrder = true }
+}
+
+// IgnoreGasLimits ignores the gas limits of submessages
+func IgnoreGasLimits() CompareOption {
+	return func(c *compareConfig) { c.ignoreGas = true }
+}
+
+// IgnoreEvents ignores the custom events of the responses
+func IgnoreEvents() CompareOption {
+	return func(c *compareConfig) { c.ignoreEvents = true }
+}
+
+// ResponsesEqual checks if two responses are equivalent. Empty and nil lists are considered equal.
+// Use the options to ignore differences that are not relevant for the comparison.
+func ResponsesEqual(a, b *Response, opts ...CompareOption) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	config := compareConfig{}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return reflect.DeepEqual(normalizeResponse(*a, config), normalizeResponse(*b, config))
+}
+
+// normalizeResponse returns a copy of the response in a canonical form for the given config
+func normalizeResponse(res Response, config compareConfig) Response {
+	out := Response{
+		Data:       res.Data,
+		Attributes: normalizeAttributes(res.Attributes, config),
+	}
+	if len(out.Data) == 0 {
+		out.Data = nil
+	}
+	for _, msg := range res.Messages {
+		if config.ignoreGas {
+			msg.GasLimit = nil
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+	if !config.ignoreEvents {
+		for _, event := range res.Events {
+			out.Events = append(out.Events, Event{
+				Type:       event.Type,
+				Attributes: normalizeAttributes(event.Attributes, config),
+			})
+		}
+	}
+	return out
+}
+
+func normalizeAttributes(attrs []EventAttribute, config compareConfig) []EventAttribute {
+	if len(attrs) == 0 {
+		return nil
+	}
+	out := append([]EventAttribute(nil), attrs...)
+	if config.ignoreOrder {
+		sort.Slice(out, func(i, j int) bool {
+			if out[i].Key != out[j].Key {
+				return out[i].Key < out[j].Key
+			}
+			return out[i].Value < out[j].Value
+		})
+	}
+	return out
+}
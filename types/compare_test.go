@@ -0,0 +1,70 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponsesEqual(t *testing.T) {
+	gas := uint64(5000)
+	a := &Response{
+		Messages: []SubMsg{{
+			ID:       1,
+			Msg:      CosmosMsg{Bank: &BankMsg{Burn: &BurnMsg{Amount: Coins{NewCoin(1, "uatom")}}}},
+			GasLimit: &gas,
+			ReplyOn:  ReplyAlways,
+		}},
+		Data: []byte{},
+		Attributes: []EventAttribute{
+			{Key: "action", Value: "burn"},
+			{Key: "amount", Value: "1"},
+		},
+		Events: []Event{{
+			Type: "wasm-burn",
+			Attributes: EventAttributes{
+				{Key: "a", Value: "1"},
+				{Key: "b", Value: "2"},
+			},
+		}},
+	}
+	b := &Response{
+		Messages: []SubMsg{{
+			ID:      1,
+			Msg:     CosmosMsg{Bank: &BankMsg{Burn: &BurnMsg{Amount: Coins{NewCoin(1, "uatom")}}}},
+			ReplyOn: ReplyAlways,
+		}},
+		Attributes: []EventAttribute{
+			{Key: "amount", Value: "1"},
+			{Key: "action", Value: "burn"},
+		},
+		Events: []Event{{
+			Type: "wasm-burn",
+			Attributes: EventAttributes{
+				{Key: "b", Value: "2"},
+				{Key: "a", Value: "1"},
+			},
+		}},
+	}
+
+	assert.True(t, ResponsesEqual(a, a))
+	assert.False(t, ResponsesEqual(a, b))
+	assert.False(t, ResponsesEqual(a, b, IgnoreAttributeOrder()))
+	assert.False(t, ResponsesEqual(a, b, IgnoreGasLimits()))
+	assert.True(t, ResponsesEqual(a, b, IgnoreAttributeOrder(), IgnoreGasLimits()))
+
+	// values still have to match
+	b.Attributes = []EventAttribute{
+		{Key: "amount", Value: "2"},
+		{Key: "action", Value: "burn"},
+	}
+	assert.False(t, ResponsesEqual(a, b, IgnoreAttributeOrder(), IgnoreGasLimits()))
+	b.Attributes = a.Attributes
+
+	b.Events = nil
+	assert.False(t, ResponsesEqual(a, b, IgnoreAttributeOrder(), IgnoreGasLimits()))
+	assert.True(t, ResponsesEqual(a, b, IgnoreAttributeOrder(), IgnoreGasLimits(), IgnoreEvents()))
+
+	assert.True(t, ResponsesEqual(nil, nil))
+	assert.False(t, ResponsesEqual(a, nil))
+}
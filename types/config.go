@@ -0,0 +1,102 @@
+package types
+
+import "time"
+
+// VMConfig contains the configuration of a VM instance. See NewVM for the meaning of the cache related fields.
+type VMConfig struct {
+	DataDir           string
+	SupportedFeatures string
+	MemoryLimit       uint32
+	PrintDebug        bool
+	CacheSize         uint32
+	// InitialMemoryPages is the maximum initial memory size (in Wasm pages of 64 KiB) a contract may declare.
+	// Set to 0 for no limit other than MemoryLimit.
+	InitialMemoryPages uint32
+	// MaxMemoryPages is the maximum memory size (in Wasm pages of 64 KiB) a contract may declare,
+	// both as initial and as maximum size. It must not exceed MemoryLimit. The limit is only checked
+	// when storing code, so contracts whose memory has no declared maximum (the default of rustc) are rejected.
+	// Set to 0 for no limit other than MemoryLimit.
+	MaxMemoryPages uint32
+	// EntryPointGas contains the base gas charged for each call into a contract
+	EntryPointGas EntryPointGas
+	// IteratorGasCosts contains the fixed gas charged by the iterator callbacks of this VM
+	IteratorGasCosts IteratorGasCosts
+	// EchoEnv includes the env a contract was executed with in ExecuteOutput
+	EchoEnv bool
+	// StrictJSON decodes contract results with UnmarshalStrict instead of json.Unmarshal
+	StrictJSON bool
+	// MaxEventsPerResponse is the maximum number of custom events a contract response may contain.
+	// Set to 0 for no limit.
+	MaxEventsPerResponse uint32
+	// MaxAttributesPerResponse is the maximum number of attributes a contract response may contain,
+	// counting both the response's own attributes and those of its events. Set to 0 for no limit.
+	MaxAttributesPerResponse uint32
+	// UniqueEventTypes rejects contract responses containing two custom events of the same type
+	UniqueEventTypes bool
+	// CanonicalEvents sorts the attributes and events of contract responses (see Events.SortCanonical),
+	// such that their order does not depend on how the contract built them
+	CanonicalEvents bool
+	// CaptureInputs includes the encoded inputs passed to the contract in ExecuteOutput
+	CaptureInputs bool
+	// StrictSubMessages rejects contract responses containing submessages for which SubMsg.Validate fails
+	StrictSubMessages bool
+	// ReplyErrorCodes allows passing SubMsgResult.ErrCode to contracts in VM.Reply. Without it, replies
+	// with an error code are rejected, since contracts built with cosmwasm-std 1.x fail to parse them.
+	ReplyErrorCodes bool
+	// TrackQueryReads records the store keys read by queries in QueryOutput.AccessedKeys
+	TrackQueryReads bool
+	// OnCacheSoftLimit is called when the utilization of the memory cache crosses CacheSoftLimitRatio
+	// of CacheSize, before the cache starts evicting modules. It is called again only after the
+	// utilization dropped below the ratio in between. Leave nil to disable.
+	OnCacheSoftLimit func(used, capacity uint64)
+	// CacheSoftLimitRatio is the utilization of the memory cache (between 0 and 1) at which
+	// OnCacheSoftLimit is called, e.g. 0.9
+	CacheSoftLimitRatio float64
+	// AuditSink is called after every call of a contract entry point with a record of the call, also
+	// for failed calls. It is called synchronously on the calling goroutine, so it must not block:
+	// implementations should hand the entry off, e.g. to a buffered channel, and write it asynchronously.
+	// Leave nil to disable.
+	AuditSink func(entry AuditEntry)
+	// CodeReadCacheMiB is the size of an in-process cache for the results of VM.GetCode in MiB.
+	// Set to 0 to disable.
+	CodeReadCacheMiB uint32
+	// CodeChunkSize is the size in bytes of the chunks in which VM.GetCodeTo copies code.
+	// Defaults to 256 KiB if 0.
+	CodeChunkSize uint32
+}
+
+// EntryPointGas contains a fixed amount of gas per entry point that is charged before the
+// contract is executed, on top of the gas used by the contract itself.
+// The zero value charges nothing.
+type EntryPointGas struct {
+	Instantiate uint64
+	Execute     uint64
+	Query       uint64
+	Migrate     uint64
+	Sudo        uint64
+	Reply       uint64
+	// IBC is charged for all IBC entry points
+	IBC uint64
+}
+
+// IteratorGasCosts contains fixed gas costs charged by the iterator callbacks on top of the
+// gas consumed by the KVStore. Scan is charged for each iterator creation and Next for each
+// call to next, including the final one that reaches the end of the iterator.
+// The costs are consumed in the gas meter of the contract call if it implements
+// ConsumeGas(amount uint64, descriptor string). Otherwise they are added to the gas
+// used reported by the VM.
+// The zero value (the default) charges nothing extra.
+type IteratorGasCosts struct {
+	Scan uint64
+	Next uint64
+}
+
+// ExecutionOptions contains per call options for VM.ExecuteWithOptions and VM.QueryWithOptions.
+// The zero value disables all options.
+type ExecutionOptions struct {
+	// ExecutionTimeout aborts the contract call with an ExecutionTimeoutError once it ran
+	// for longer than this. 0 disables the timeout.
+	// Wall-clock time differs between nodes, so this must only be enabled where
+	// non-determinism is acceptable, e.g. for query endpoints.
+	ExecutionTimeout time.Duration
+}
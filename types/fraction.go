@@ -25,3 +25,8 @@ func (f *UFraction) Mul(m uint64) UFraction {
 func (f UFraction) Floor() uint64 {
 	return f.Numerator / f.Denominator
 }
+
+// DeserializationGas returns the gas for deserializing size bytes when f is the cost per byte
+func (f UFraction) DeserializationGas(size int) uint64 {
+	return f.Mul(uint64(size)).Floor()
+}
@@ -81,3 +81,47 @@ func TestIbcTimeoutDeserialization(t *testing.T) {
 		Timestamp: 0,
 	}, timeout3)
 }
+
+func TestIbcPacketAckMsgRoundTrip(t *testing.T) {
+	msg := IBCPacketAckMsg{
+		Acknowledgement: IBCAcknowledgement{Data: []byte{0x00, 0x01, 0xfe, 0xff}},
+		OriginalPacket: IBCPacket{
+			Data:     []byte(`{"who_am_i":{}}`),
+			Src:      IBCEndpoint{PortID: "their-port", ChannelID: "channel-7"},
+			Dest:     IBCEndpoint{PortID: "my-port", ChannelID: "channel-3"},
+			Sequence: 27,
+			Timeout:  IBCTimeout{Timestamp: 1578939743_987654321},
+		},
+		Relayer: "relayer",
+	}
+	bz, err := json.Marshal(msg)
+	require.NoError(t, err)
+	assert.Contains(t, string(bz), `"acknowledgement":{"data":"AAH+/w=="}`)
+	var decoded IBCPacketAckMsg
+	err = json.Unmarshal(bz, &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, msg, decoded)
+}
+
+func TestIbcChannelRoundTrip(t *testing.T) {
+	for _, order := range []IBCOrder{Ordered, Unordered} {
+		msg := IBCChannelConnectMsg{
+			OpenConfirm: &IBCOpenConfirm{
+				Channel: IBCChannel{
+					Endpoint:             IBCEndpoint{PortID: "my-port", ChannelID: "channel-3"},
+					CounterpartyEndpoint: IBCEndpoint{PortID: "their-port", ChannelID: "channel-7"},
+					Order:                order,
+					Version:              "ibc-reflect-v1",
+					ConnectionID:         "connection-2",
+				},
+			},
+		}
+		bz, err := json.Marshal(msg)
+		require.NoError(t, err)
+		assert.Contains(t, string(bz), `"order":"`+order+`","version":"ibc-reflect-v1"`)
+		var decoded IBCChannelConnectMsg
+		err = json.Unmarshal(bz, &decoded)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decoded)
+	}
+}
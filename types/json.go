@@ -0,0 +1,24 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// UnmarshalStrict is like json.Unmarshal but never decodes a number into a float64.
+// Numbers in untyped fields (interface{}) are kept as json.Number, so large integers like
+// gas limits or Uint128 amounts do not silently lose precision. Integer fields are decoded
+// exactly by encoding/json and values that do not fit are an error.
+// Trailing data after the JSON value is an error as well.
+func UnmarshalStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
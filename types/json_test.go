@@ -0,0 +1,46 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalStrict(t *testing.T) {
+	// 2^60+1 cannot be represented exactly as a float64
+	const large = uint64(1<<60) + 1
+	gasLimit := large
+	msg := SubMsg{ID: large, ReplyOn: ReplyAlways, GasLimit: &gasLimit}
+	bz, err := json.Marshal(msg)
+	require.NoError(t, err)
+
+	var typed SubMsg
+	err = UnmarshalStrict(bz, &typed)
+	require.NoError(t, err)
+	assert.Equal(t, msg, typed)
+
+	// untyped values keep full precision
+	var untyped map[string]interface{}
+	err = UnmarshalStrict(bz, &untyped)
+	require.NoError(t, err)
+	assert.Equal(t, json.Number("1152921504606846977"), untyped["id"])
+	bz2, err := json.Marshal(untyped)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(bz), string(bz2))
+
+	// while json.Unmarshal goes through float64
+	var lossy map[string]interface{}
+	err = json.Unmarshal(bz, &lossy)
+	require.NoError(t, err)
+	assert.NotEqual(t, large, uint64(lossy["id"].(float64)))
+
+	// overflow is an error
+	err = UnmarshalStrict([]byte(`{"id":18446744073709551616,"reply_on":"never"}`), &typed)
+	require.Error(t, err)
+
+	// trailing data is an error
+	err = UnmarshalStrict([]byte(`{"id":1,"reply_on":"never"} {}`), &typed)
+	require.Error(t, err)
+}
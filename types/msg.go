@@ -3,6 +3,8 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 )
 
 //------- Results / Msgs -------------
@@ -29,12 +31,190 @@ type Response struct {
 	// custom events (separate from the main one that contains the attributes
 	// above)
 	Events []Event `json:"events"`
+	// SubResponses is not part of the contract result. It can be filled by the caller with the
+	// responses of executing the messages, where SubResponses[i] belongs to Messages[i]
+	// (nil if there is none). See WalkMessages.
+	SubResponses []*Response `json:"-"`
+}
+
+// WalkMessages calls fn for each message of the response and, depth first, for the messages of
+// the corresponding SubResponses. The messages of this response have depth 0.
+func (r Response) WalkMessages(fn func(depth int, msg SubMsg)) {
+	r.walkMessages(0, fn)
+}
+
+func (r Response) walkMessages(depth int, fn func(depth int, msg SubMsg)) {
+	for i, msg := range r.Messages {
+		fn(depth, msg)
+		if i < len(r.SubResponses) && r.SubResponses[i] != nil {
+			r.SubResponses[i].walkMessages(depth+1, fn)
+		}
+	}
+}
+
+// Validate checks the response against the response limits of cfg (see ValidateResponseParts)
+func (r Response) Validate(cfg VMConfig) error {
+	return ValidateResponseParts(cfg, r.Messages, r.Attributes, r.Events)
+}
+
+// ValidateResponseParts checks the parts of a contract response against the response limits of cfg,
+// which are MaxEventsPerResponse, UniqueEventTypes, MaxAttributesPerResponse and StrictSubMessages,
+// in this order. It returns the first violation, which wraps ErrTooManyEvents, ErrDuplicateEventType,
+// ErrTooManyAttributes or ErrSuspiciousSubMsg. This is used for the responses of all entry points,
+// including the IBC ones, which do not return a Response.
+func ValidateResponseParts(cfg VMConfig, msgs []SubMsg, attributes []EventAttribute, events []Event) error {
+	if cfg.MaxEventsPerResponse != 0 && uint64(len(events)) > uint64(cfg.MaxEventsPerResponse) {
+		return ErrTooManyEvents
+	}
+	if cfg.UniqueEventTypes {
+		seen := make(map[string]bool, len(events))
+		for _, event := range events {
+			if seen[event.Type] {
+				return fmt.Errorf("%w: %s", ErrDuplicateEventType, event.Type)
+			}
+			seen[event.Type] = true
+		}
+	}
+	if cfg.MaxAttributesPerResponse != 0 {
+		count := uint64(len(attributes))
+		for _, event := range events {
+			count += uint64(len(event.Attributes))
+		}
+		if count > uint64(cfg.MaxAttributesPerResponse) {
+			return ErrTooManyAttributes
+		}
+	}
+	if cfg.StrictSubMessages {
+		for _, msg := range msgs {
+			if err := msg.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExecuteOutput contains the result of VM.ExecuteWithOutput
+type ExecuteOutput struct {
+	Response *Response
+	GasUsed  uint64
+	// DeserializationGas is the part of GasUsed charged for deserializing the contract result
+	// (see UFraction.DeserializationGas)
+	DeserializationGas uint64
+	// Env is the env the contract was executed with. Only set if VMConfig.EchoEnv is enabled.
+	Env *Env
+	// HostCallCounts contains the number of calls from the contract into the host by operation name
+	HostCallCounts map[string]uint64
+	// RawInputs contains the exact bytes passed to the contract. Only set if VMConfig.CaptureInputs is enabled.
+	RawInputs *RawInputs
+	// ColdCallDuration is the duration of the whole call if a module was in none of the caches and had to
+	// be compiled during the call, e.g. after the file system cache was cleared. It is 0 otherwise, also
+	// for modules loaded from the file system cache. This is detected from the VM wide cache misses, so
+	// a compile in a concurrent call on the same VM or in a smart query into another contract is counted too.
+	ColdCallDuration time.Duration
+}
+
+// QueryOutput contains the result of VM.QueryWithOutput
+type QueryOutput struct {
+	Data    []byte
+	GasUsed uint64
+	// AccessedKeys contains the distinct store keys read by the query in ascending order, including
+	// the keys returned by iterators. Only set if VMConfig.TrackQueryReads is enabled.
+	AccessedKeys [][]byte
+}
+
+// RawResponse contains the JSON encoding of a contract result as returned by the contract
+type RawResponse struct {
+	// Result is the complete ContractResult
+	Result []byte
+	// Attributes and Events are the attributes and events of the response. They are nil if the
+	// contract returned an error.
+	Attributes []byte
+	Events     []byte
+}
+
+// NewRawResponse extracts the attributes and events from the JSON encoding of a ContractResult
+// without decoding them
+func NewRawResponse(result []byte) (*RawResponse, error) {
+	var parts struct {
+		Ok *struct {
+			Attributes json.RawMessage `json:"attributes"`
+			Events     json.RawMessage `json:"events"`
+		} `json:"ok"`
+	}
+	if err := json.Unmarshal(result, &parts); err != nil {
+		return nil, err
+	}
+	raw := RawResponse{Result: result}
+	if parts.Ok != nil {
+		raw.Attributes = parts.Ok.Attributes
+		raw.Events = parts.Ok.Events
+	}
+	return &raw, nil
+}
+
+// RawInputs contains the JSON encoded inputs of a contract call
+type RawInputs struct {
+	Env  []byte
+	Info []byte
+	Msg  []byte
+}
+
+// ReferencedDenoms returns the sorted list of distinct coin denoms used in the amounts
+// of the messages in this response. Custom and Stargate messages are opaque and not inspected.
+func (r Response) ReferencedDenoms() []string {
+	seen := make(map[string]bool)
+	add := func(coins ...Coin) {
+		for _, coin := range coins {
+			seen[coin.Denom] = true
+		}
+	}
+	for _, sub := range r.Messages {
+		msg := sub.Msg
+		if msg.Bank != nil {
+			if msg.Bank.Send != nil {
+				add(msg.Bank.Send.Amount...)
+			}
+			if msg.Bank.Burn != nil {
+				add(msg.Bank.Burn.Amount...)
+			}
+		}
+		if msg.IBC != nil && msg.IBC.Transfer != nil {
+			add(msg.IBC.Transfer.Amount)
+		}
+		if msg.Staking != nil {
+			if msg.Staking.Delegate != nil {
+				add(msg.Staking.Delegate.Amount)
+			}
+			if msg.Staking.Undelegate != nil {
+				add(msg.Staking.Undelegate.Amount)
+			}
+			if msg.Staking.Redelegate != nil {
+				add(msg.Staking.Redelegate.Amount)
+			}
+		}
+		if msg.Wasm != nil {
+			if msg.Wasm.Execute != nil {
+				add(msg.Wasm.Execute.Funds...)
+			}
+			if msg.Wasm.Instantiate != nil {
+				add(msg.Wasm.Instantiate.Funds...)
+			}
+		}
+	}
+	denoms := make([]string, 0, len(seen))
+	for denom := range seen {
+		denoms = append(denoms, denom)
+	}
+	sort.Strings(denoms)
+	return denoms
 }
 
 // Events must encode empty array as []
 type Events []Event
 
-// MarshalJSON ensures that we get [] for empty arrays
+// MarshalJSON ensures that we get [] for empty arrays.
+// The events are encoded in slice order, use SortCanonical for an order independent encoding.
 func (e Events) MarshalJSON() ([]byte, error) {
 	if len(e) == 0 {
 		return []byte("[]"), nil
@@ -57,6 +237,21 @@ func (e *Events) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// SortCanonical sorts the attributes of every event (see EventAttributes.SortCanonical) and then the
+// events by type and attributes, in place. Two event lists with the same events in a different
+// order are equal after sorting.
+func (e Events) SortCanonical() {
+	for _, event := range e {
+		event.Attributes.SortCanonical()
+	}
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Type != e[j].Type {
+			return e[i].Type < e[j].Type
+		}
+		return e[i].Attributes.less(e[j].Attributes)
+	})
+}
+
 type Event struct {
 	Type       string          `json:"type"`
 	Attributes EventAttributes `json:"attributes"`
@@ -65,7 +260,8 @@ type Event struct {
 // EventAttributes must encode empty array as []
 type EventAttributes []EventAttribute
 
-// MarshalJSON ensures that we get [] for empty arrays
+// MarshalJSON ensures that we get [] for empty arrays.
+// The attributes are encoded in slice order, use SortCanonical for an order independent encoding.
 func (a EventAttributes) MarshalJSON() ([]byte, error) {
 	if len(a) == 0 {
 		return []byte("[]"), nil
@@ -88,6 +284,30 @@ func (a *EventAttributes) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// SortCanonical sorts the attributes by key and then value, in place.
+// Attributes with equal key and value keep their relative order.
+func (a EventAttributes) SortCanonical() {
+	sort.SliceStable(a, func(i, j int) bool {
+		if a[i].Key != a[j].Key {
+			return a[i].Key < a[j].Key
+		}
+		return a[i].Value < a[j].Value
+	})
+}
+
+// less compares two attribute lists lexicographically
+func (a EventAttributes) less(other EventAttributes) bool {
+	for i := 0; i < len(a) && i < len(other); i++ {
+		if a[i].Key != other[i].Key {
+			return a[i].Key < other[i].Key
+		}
+		if a[i].Value != other[i].Value {
+			return a[i].Value < other[i].Value
+		}
+	}
+	return len(a) < len(other)
+}
+
 // EventAttribute
 type EventAttribute struct {
 	Key   string `json:"key"`
@@ -107,6 +327,33 @@ type CosmosMsg struct {
 	Wasm         *WasmMsg         `json:"wasm,omitempty"`
 }
 
+// Kind returns the name of the variant that is set, i.e. the JSON key of the message
+// (e.g. "bank" or "wasm"). An error is returned unless exactly one variant is set.
+func (m CosmosMsg) Kind() (string, error) {
+	var kinds []string
+	add := func(set bool, kind string) {
+		if set {
+			kinds = append(kinds, kind)
+		}
+	}
+	add(m.Bank != nil, "bank")
+	add(m.Custom != nil, "custom")
+	add(m.Distribution != nil, "distribution")
+	add(m.Gov != nil, "gov")
+	add(m.IBC != nil, "ibc")
+	add(m.Staking != nil, "staking")
+	add(m.Stargate != nil, "stargate")
+	add(m.Wasm != nil, "wasm")
+	switch len(kinds) {
+	case 0:
+		return "", fmt.Errorf("no variant of CosmosMsg set")
+	case 1:
+		return kinds[0], nil
+	default:
+		return "", fmt.Errorf("multiple variants of CosmosMsg set: %v", kinds)
+	}
+}
+
 type BankMsg struct {
 	Send *SendMsg `json:"send,omitempty"`
 	Burn *BurnMsg `json:"burn,omitempty"`
@@ -0,0 +1,196 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseReferencedDenoms(t *testing.T) {
+	res := Response{
+		Messages: []SubMsg{
+			{Msg: CosmosMsg{Bank: &BankMsg{Send: &SendMsg{
+				ToAddress: "bob",
+				Amount:    Coins{NewCoin(12, "uatom"), NewCoin(3, "ulink")},
+			}}}},
+			{Msg: CosmosMsg{Bank: &BankMsg{Send: &SendMsg{
+				ToAddress: "alice",
+				Amount:    Coins{NewCoin(7, "uatom")},
+			}}}},
+			{Msg: CosmosMsg{IBC: &IBCMsg{Transfer: &TransferMsg{
+				ChannelID: "channel-7",
+				ToAddress: "cosmos1abc",
+				Amount:    NewCoin(1, "ibc/27394FB092D2ECCD56123C74F36E4C1F926001CEADA9CA97EA622B25F41E5EB2"),
+			}}}},
+			{Msg: CosmosMsg{Staking: &StakingMsg{Delegate: &DelegateMsg{
+				Validator: "val",
+				Amount:    NewCoin(100, "stake"),
+			}}}},
+			{Msg: CosmosMsg{Custom: []byte(`{"foo":"bar"}`)}},
+		},
+	}
+	expected := []string{
+		"ibc/27394FB092D2ECCD56123C74F36E4C1F926001CEADA9CA97EA622B25F41E5EB2",
+		"stake",
+		"uatom",
+		"ulink",
+	}
+	assert.Equal(t, expected, res.ReferencedDenoms())
+
+	assert.Equal(t, []string{}, Response{}.ReferencedDenoms())
+}
+
+func TestResponseWalkMessages(t *testing.T) {
+	res := Response{
+		Messages: []SubMsg{{ID: 1}, {ID: 2}, {ID: 3}},
+		SubResponses: []*Response{
+			{Messages: []SubMsg{{ID: 11}, {ID: 12}}},
+			nil,
+			{
+				Messages:     []SubMsg{{ID: 31}},
+				SubResponses: []*Response{{Messages: []SubMsg{{ID: 311}}}},
+			},
+		},
+	}
+
+	type visit struct {
+		depth int
+		id    uint64
+	}
+	var visits []visit
+	res.WalkMessages(func(depth int, msg SubMsg) {
+		visits = append(visits, visit{depth, msg.ID})
+	})
+	expected := []visit{
+		{0, 1},
+		{1, 11},
+		{1, 12},
+		{0, 2},
+		{0, 3},
+		{1, 31},
+		{2, 311},
+	}
+	assert.Equal(t, expected, visits)
+}
+
+func TestCosmosMsgKind(t *testing.T) {
+	cases := map[string]CosmosMsg{
+		"bank":         {Bank: &BankMsg{}},
+		"custom":       {Custom: []byte(`{"foo":"bar"}`)},
+		"distribution": {Distribution: &DistributionMsg{}},
+		"gov":          {Gov: &GovMsg{}},
+		"ibc":          {IBC: &IBCMsg{}},
+		"staking":      {Staking: &StakingMsg{}},
+		"stargate":     {Stargate: &StargateMsg{}},
+		"wasm":         {Wasm: &WasmMsg{}},
+	}
+	for expected, msg := range cases {
+		kind, err := msg.Kind()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, kind)
+	}
+
+	_, err := CosmosMsg{}.Kind()
+	assert.Error(t, err)
+	_, err = CosmosMsg{Bank: &BankMsg{}, Wasm: &WasmMsg{}}.Kind()
+	assert.ErrorContains(t, err, "multiple variants")
+}
+
+func TestEventsSortCanonical(t *testing.T) {
+	build := func(reverse bool) Events {
+		attrs := EventAttributes{{Key: "b", Value: "2"}, {Key: "a", Value: "2"}, {Key: "a", Value: "1"}}
+		events := Events{
+			{Type: "transfer", Attributes: EventAttributes{{Key: "amount", Value: "5"}}},
+			{Type: "hackatom", Attributes: attrs},
+			{Type: "transfer", Attributes: EventAttributes{{Key: "amount", Value: "3"}}},
+		}
+		if reverse {
+			for i, j := 0, len(attrs)-1; i < j; i, j = i+1, j-1 {
+				attrs[i], attrs[j] = attrs[j], attrs[i]
+			}
+			for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+				events[i], events[j] = events[j], events[i]
+			}
+		}
+		return events
+	}
+
+	first, second := build(false), build(true)
+	bz1, err := json.Marshal(first)
+	require.NoError(t, err)
+	bz2, err := json.Marshal(second)
+	require.NoError(t, err)
+	// marshaling preserves the order
+	require.NotEqual(t, bz1, bz2)
+
+	first.SortCanonical()
+	second.SortCanonical()
+	bz1, err = json.Marshal(first)
+	require.NoError(t, err)
+	bz2, err = json.Marshal(second)
+	require.NoError(t, err)
+	require.Equal(t, string(bz1), string(bz2))
+	require.Equal(t, `[{"type":"hackatom","attributes":[{"key":"a","value":"1"},{"key":"a","value":"2"},{"key":"b","value":"2"}]},`+
+		`{"type":"transfer","attributes":[{"key":"amount","value":"3"}]},{"type":"transfer","attributes":[{"key":"amount","value":"5"}]}]`, string(bz1))
+}
+
+func TestResponseValidate(t *testing.T) {
+	gasLimit := uint64(5000)
+	attr := EventAttribute{Key: "foo", Value: "bar"}
+	response := Response{
+		Messages:   []SubMsg{{ID: 1, ReplyOn: ReplyNever, GasLimit: &gasLimit}},
+		Attributes: []EventAttribute{attr},
+		Events: []Event{
+			{Type: "foo", Attributes: EventAttributes{attr}},
+			{Type: "foo", Attributes: EventAttributes{attr}},
+		},
+	}
+
+	cases := map[string]struct {
+		cfg    VMConfig
+		expErr error
+	}{
+		"no limits": {
+			cfg: VMConfig{},
+		},
+		"limits not exceeded": {
+			cfg: VMConfig{MaxEventsPerResponse: 2, MaxAttributesPerResponse: 3},
+		},
+		"too many events": {
+			cfg:    VMConfig{MaxEventsPerResponse: 1},
+			expErr: ErrTooManyEvents,
+		},
+		"duplicate event type": {
+			cfg:    VMConfig{UniqueEventTypes: true},
+			expErr: ErrDuplicateEventType,
+		},
+		"too many attributes": {
+			cfg:    VMConfig{MaxAttributesPerResponse: 2},
+			expErr: ErrTooManyAttributes,
+		},
+		"suspicious submessage": {
+			cfg:    VMConfig{StrictSubMessages: true},
+			expErr: ErrSuspiciousSubMsg,
+		},
+		"first violation is returned": {
+			cfg:    VMConfig{MaxEventsPerResponse: 1, MaxAttributesPerResponse: 1, StrictSubMessages: true},
+			expErr: ErrTooManyEvents,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := response.Validate(tc.cfg)
+			if tc.expErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.expErr)
+		})
+	}
+
+	// the parts of responses of the IBC entry points are checked the same way
+	err := ValidateResponseParts(VMConfig{UniqueEventTypes: true}, nil, nil, response.Events)
+	require.EqualError(t, err, "duplicate event type in contract response: foo")
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"fmt"
 )
 
 //-------- Queries --------
@@ -33,6 +34,22 @@ type Querier interface {
 	GasConsumed() uint64
 }
 
+// QueryGasConfig contains the per byte costs used by QueryResponseGas
+type QueryGasConfig struct {
+	// PerRequestByte is the gas charged per byte of the JSON encoded request
+	PerRequestByte uint64
+	// PerResponseByte is the gas charged per byte of the response
+	PerResponseByte uint64
+}
+
+// QueryResponseGas returns the gas a querier should charge for answering req with resp.
+// It only depends on the sizes of the JSON encoded request and the response, such that it is
+// deterministic across nodes. A request that cannot be encoded counts as 0 bytes.
+func QueryResponseGas(req QueryRequest, resp []byte, cfg QueryGasConfig) uint64 {
+	reqBin, _ := json.Marshal(req)
+	return cfg.PerRequestByte*uint64(len(reqBin)) + cfg.PerResponseByte*uint64(len(resp))
+}
+
 // this is a thin wrapper around the desired Go API to give us types closer to Rust FFI
 func RustQuery(querier Querier, binRequest []byte, gasLimit uint64) QuerierResult {
 	var request QueryRequest
@@ -89,6 +106,33 @@ type QueryRequest struct {
 	Wasm     *WasmQuery      `json:"wasm,omitempty"`
 }
 
+// CustomQuery is a chain specific query sent via QueryRequest.Custom. Like all
+// Rust enums it is encoded as an object with exactly one key, which is used as the
+// namespace to route the query. Request holds the namespace specific payload.
+type CustomQuery struct {
+	Namespace string
+	Request   json.RawMessage
+}
+
+func (q CustomQuery) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]json.RawMessage{q.Namespace: q.Request})
+}
+
+func (q *CustomQuery) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 1 {
+		return fmt.Errorf("custom query must have exactly one namespace, got %d", len(raw))
+	}
+	for namespace, request := range raw {
+		q.Namespace = namespace
+		q.Request = request
+	}
+	return nil
+}
+
 type BankQuery struct {
 	Supply      *SupplyQuery      `json:"supply,omitempty"`
 	Balance     *BalanceQuery     `json:"balance,omitempty"`
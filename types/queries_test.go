@@ -113,3 +113,30 @@ func TestQueryResponseWithEmptyData(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomQuerySerialization(t *testing.T) {
+	var query CustomQuery
+	err := json.Unmarshal([]byte(`{"capitalized":{"text":"foo"}}`), &query)
+	require.NoError(t, err)
+	assert.Equal(t, "capitalized", query.Namespace)
+	assert.Equal(t, `{"text":"foo"}`, string(query.Request))
+
+	bz, err := json.Marshal(query)
+	require.NoError(t, err)
+	assert.Equal(t, `{"capitalized":{"text":"foo"}}`, string(bz))
+
+	// exactly one namespace must be set
+	err = json.Unmarshal([]byte(`{}`), &query)
+	require.ErrorContains(t, err, "exactly one namespace")
+	err = json.Unmarshal([]byte(`{"a":{},"b":{}}`), &query)
+	require.ErrorContains(t, err, "exactly one namespace")
+}
+
+func TestQueryResponseGas(t *testing.T) {
+	req := QueryRequest{Bank: &BankQuery{Balance: &BalanceQuery{Address: "foo", Denom: "ATOM"}}}
+	// {"bank":{"balance":{"address":"foo","denom":"ATOM"}}} has 53 bytes
+	resp := []byte(`{"amount":{"denom":"ATOM","amount":"7"}}`)
+	cfg := QueryGasConfig{PerRequestByte: 3, PerResponseByte: 2}
+	assert.Equal(t, uint64(53*3+40*2), QueryResponseGas(req, resp, cfg))
+	assert.Equal(t, uint64(0), QueryResponseGas(req, resp, QueryGasConfig{}))
+}
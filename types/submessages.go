@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
@@ -60,16 +61,138 @@ type SubMsg struct {
 	ReplyOn  replyOn   `json:"reply_on"`
 }
 
+// ErrSuspiciousSubMsg is returned by SubMsg.Validate for combinations of settings that are likely mistakes
+var ErrSuspiciousSubMsg = errors.New("suspicious submessage")
+
+// Validate checks the submessage for combinations of ReplyOn and GasLimit that are valid but likely
+// mistakes. A gas limit without a reply makes running out of gas abort the whole transaction instead
+// of being handled by the contract, and a gas limit of 0 makes the submessage fail in any case.
+func (m SubMsg) Validate() error {
+	if m.GasLimit == nil {
+		return nil
+	}
+	if m.ReplyOn == ReplyNever {
+		return fmt.Errorf("%w %d: gas limit set with reply_on never, so running out of gas cannot be handled", ErrSuspiciousSubMsg, m.ID)
+	}
+	if *m.GasLimit == 0 {
+		return fmt.Errorf("%w %d: gas limit of 0 with reply_on %s, so the submessage always runs out of gas", ErrSuspiciousSubMsg, m.ID, m.ReplyOn)
+	}
+	return nil
+}
+
 type Reply struct {
 	ID     uint64       `json:"id"`
 	Result SubMsgResult `json:"result"`
 }
 
+// BuildReply creates the Reply for the submessage with the given ID from the result of
+// dispatching it. If execErr is set, the Err branch is filled with its message and
+// events and data are ignored. Otherwise the Ok branch contains events and data.
+func BuildReply(id uint64, events Events, data []byte, execErr error) Reply {
+	if execErr != nil {
+		return Reply{
+			ID:     id,
+			Result: SubMsgResult{Err: execErr.Error()},
+		}
+	}
+	if events == nil {
+		events = Events{}
+	}
+	return Reply{
+		ID: id,
+		Result: SubMsgResult{
+			Ok: &SubMsgResponse{
+				Events: events,
+				Data:   data,
+			},
+		},
+	}
+}
+
+// abciCoder is implemented by errors carrying an ABCI error code, like the registered errors of the SDK
+type abciCoder interface {
+	ABCICode() uint32
+}
+
+// BuildReplyWithErrCode works like BuildReply but additionally sets ErrCode if execErr or an error
+// it wraps has an ABCICode method, like the registered errors of the SDK. Use it only for contracts
+// that accept the error_code field (see SubMsgResult and VMConfig.ReplyErrorCodes).
+func BuildReplyWithErrCode(id uint64, events Events, data []byte, execErr error) Reply {
+	reply := BuildReply(id, events, data, execErr)
+	var coder abciCoder
+	if execErr != nil && errors.As(execErr, &coder) {
+		code := coder.ABCICode()
+		reply.Result.ErrCode = &code
+	}
+	return reply
+}
+
+// ErrNoReplyHandler is returned when a contract without a reply entry point returns
+// submessages that expect a reply
+var ErrNoReplyHandler = errors.New("contract has no reply entry point but expects a reply for a submessage")
+
+// ErrReplyFailed is returned by VM.Reply when the contract's reply entry point returned an error.
+// The caller must roll back the whole transaction, including the submessage.
+type ErrReplyFailed struct {
+	SubMsgID uint64
+	// Msg is the error message returned by the contract
+	Msg string
+}
+
+var _ error = ErrReplyFailed{}
+
+func (e ErrReplyFailed) Error() string {
+	return fmt.Sprintf("reply for submessage %d failed: %s", e.SubMsgID, e.Msg)
+}
+
+// ErrUnexpectedReplyID is returned by ReplyTracker for replies to IDs that are not outstanding
+var ErrUnexpectedReplyID = errors.New("unexpected reply ID")
+
+// ReplyTracker records the IDs of dispatched submessages and validates that replies only
+// refer to those. This is a helper for simulators and tests.
+// It is not safe for concurrent use.
+type ReplyTracker struct {
+	outstanding map[uint64]bool
+}
+
+func NewReplyTracker() *ReplyTracker {
+	return &ReplyTracker{outstanding: make(map[uint64]bool)}
+}
+
+// Dispatch records the IDs of the given submessages that expect a reply.
+// Submessages with ReplyNever are ignored.
+func (t *ReplyTracker) Dispatch(msgs ...SubMsg) {
+	for _, msg := range msgs {
+		if msg.ReplyOn != ReplyNever {
+			t.outstanding[msg.ID] = true
+		}
+	}
+}
+
+// Validate checks that the reply refers to an outstanding submessage and marks it as answered.
+func (t *ReplyTracker) Validate(reply Reply) error {
+	if !t.outstanding[reply.ID] {
+		return fmt.Errorf("%w: %d", ErrUnexpectedReplyID, reply.ID)
+	}
+	delete(t.outstanding, reply.ID)
+	return nil
+}
+
+// Outstanding returns the number of submessages still waiting for a reply
+func (t *ReplyTracker) Outstanding() int {
+	return len(t.outstanding)
+}
+
 // SubMsgResult is the raw response we return from wasmd after executing a SubMsg.
 // This mirrors Rust's SubMsgResult.
+//
+// ErrCode optionally contains the code of the error the submessage failed with. It is only encoded
+// if set. Contracts built with cosmwasm-std 1.x do not accept the additional field, so it must only be
+// set for contracts that expect it. VM.Reply rejects it unless VMConfig.ReplyErrorCodes is enabled.
 type SubMsgResult struct {
-	Ok  *SubMsgResponse `json:"ok,omitempty"`
-	Err string          `json:"error,omitempty"`
+	Ok      *SubMsgResponse `json:"ok,omitempty"`
+	Err     string          `json:"error,omitempty"`
+	ErrCode *uint32         `json:"error_code,omitempty"`
 }
 
 // SubMsgResponse contains information we get back from a successful sub message execution,
@@ -0,0 +1,170 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyTracker(t *testing.T) {
+	tracker := NewReplyTracker()
+	tracker.Dispatch(
+		SubMsg{ID: 7, ReplyOn: ReplyAlways},
+		SubMsg{ID: 8, ReplyOn: ReplyNever},
+	)
+	require.Equal(t, 1, tracker.Outstanding())
+
+	// wrong ID
+	err := tracker.Validate(Reply{ID: 6, Result: SubMsgResult{Err: "failed"}})
+	require.ErrorIs(t, err, ErrUnexpectedReplyID)
+	require.EqualError(t, err, "unexpected reply ID: 6")
+
+	// no reply expected
+	err = tracker.Validate(Reply{ID: 8, Result: SubMsgResult{Ok: &SubMsgResponse{}}})
+	require.ErrorIs(t, err, ErrUnexpectedReplyID)
+
+	err = tracker.Validate(Reply{ID: 7, Result: SubMsgResult{Ok: &SubMsgResponse{}}})
+	require.NoError(t, err)
+	require.Equal(t, 0, tracker.Outstanding())
+
+	// only one reply per submessage
+	err = tracker.Validate(Reply{ID: 7, Result: SubMsgResult{Ok: &SubMsgResponse{}}})
+	require.ErrorIs(t, err, ErrUnexpectedReplyID)
+}
+
+func TestSubMsgValidate(t *testing.T) {
+	limit := func(gas uint64) *uint64 { return &gas }
+	cases := map[string]struct {
+		msg    SubMsg
+		expErr string
+	}{
+		"no gas limit": {
+			msg: SubMsg{ID: 1, ReplyOn: ReplyNever},
+		},
+		"gas limit with reply": {
+			msg: SubMsg{ID: 1, ReplyOn: ReplyError, GasLimit: limit(5000)},
+		},
+		"gas limit with reply never": {
+			msg:    SubMsg{ID: 2, ReplyOn: ReplyNever, GasLimit: limit(5000)},
+			expErr: "suspicious submessage 2: gas limit set with reply_on never, so running out of gas cannot be handled",
+		},
+		"zero gas limit with reply never": {
+			msg:    SubMsg{ID: 3, ReplyOn: ReplyNever, GasLimit: limit(0)},
+			expErr: "suspicious submessage 3: gas limit set with reply_on never, so running out of gas cannot be handled",
+		},
+		"zero gas limit with reply on success": {
+			msg:    SubMsg{ID: 4, ReplyOn: ReplySuccess, GasLimit: limit(0)},
+			expErr: "suspicious submessage 4: gas limit of 0 with reply_on success, so the submessage always runs out of gas",
+		},
+		"zero gas limit with reply always": {
+			msg:    SubMsg{ID: 5, ReplyOn: ReplyAlways, GasLimit: limit(0)},
+			expErr: "suspicious submessage 5: gas limit of 0 with reply_on always, so the submessage always runs out of gas",
+		},
+		"zero gas limit with reply on error": {
+			msg:    SubMsg{ID: 6, ReplyOn: ReplyError, GasLimit: limit(0)},
+			expErr: "suspicious submessage 6: gas limit of 0 with reply_on error, so the submessage always runs out of gas",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.msg.Validate()
+			if tc.expErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrSuspiciousSubMsg)
+			require.EqualError(t, err, tc.expErr)
+		})
+	}
+}
+
+func TestErrReplyFailed(t *testing.T) {
+	err := ErrReplyFailed{SubMsgID: 7, Msg: "invalid reply id"}
+	require.Equal(t, "reply for submessage 7 failed: invalid reply id", err.Error())
+}
+
+func TestBuildReply(t *testing.T) {
+	events := Events{{Type: "wasm", Attributes: EventAttributes{{Key: "foo", Value: "bar"}}}}
+	reply := BuildReply(7, events, []byte{0xaa}, nil)
+	require.Equal(t, Reply{
+		ID: 7,
+		Result: SubMsgResult{
+			Ok: &SubMsgResponse{Events: events, Data: []byte{0xaa}},
+		},
+	}, reply)
+
+	// events are serialized as an empty list rather than null
+	reply = BuildReply(8, nil, nil, nil)
+	bz, err := json.Marshal(reply)
+	require.NoError(t, err)
+	require.Equal(t, `{"id":8,"result":{"ok":{"events":[]}}}`, string(bz))
+
+	reply = BuildReply(9, events, []byte{0xaa}, errors.New("out of funds"))
+	require.Equal(t, Reply{ID: 9, Result: SubMsgResult{Err: "out of funds"}}, reply)
+}
+
+// codedError mimics the registered errors of the SDK
+type codedError struct {
+	code uint32
+}
+
+func (e codedError) Error() string {
+	return "insufficient funds"
+}
+
+func (e codedError) ABCICode() uint32 {
+	return e.code
+}
+
+func TestBuildReplyWithErrCode(t *testing.T) {
+	reply := BuildReplyWithErrCode(7, nil, nil, fmt.Errorf("dispatch: %w", codedError{code: 5}))
+	code := uint32(5)
+	require.Equal(t, Reply{ID: 7, Result: SubMsgResult{Err: "dispatch: insufficient funds", ErrCode: &code}}, reply)
+
+	// errors without code
+	reply = BuildReplyWithErrCode(8, nil, nil, errors.New("out of funds"))
+	require.Equal(t, Reply{ID: 8, Result: SubMsgResult{Err: "out of funds"}}, reply)
+
+	// success
+	reply = BuildReplyWithErrCode(9, nil, nil, nil)
+	require.Equal(t, BuildReply(9, nil, nil, nil), reply)
+}
+
+func TestSubMsgResultErrCodeJSON(t *testing.T) {
+	code := uint32(5)
+	cases := map[string]struct {
+		result SubMsgResult
+		json   string
+	}{
+		"error with code": {
+			result: SubMsgResult{Err: "insufficient funds", ErrCode: &code},
+			json:   `{"error":"insufficient funds","error_code":5}`,
+		},
+		"error without code": {
+			result: SubMsgResult{Err: "insufficient funds"},
+			json:   `{"error":"insufficient funds"}`,
+		},
+		"error code 0": {
+			result: SubMsgResult{Err: "internal", ErrCode: new(uint32)},
+			json:   `{"error":"internal","error_code":0}`,
+		},
+		"ok": {
+			result: SubMsgResult{Ok: &SubMsgResponse{Events: Events{{Type: "wasm", Attributes: EventAttributes{{Key: "foo", Value: "bar"}}}}}},
+			json:   `{"ok":{"events":[{"type":"wasm","attributes":[{"key":"foo","value":"bar"}]}]}}`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			bz, err := json.Marshal(tc.result)
+			require.NoError(t, err)
+			require.JSONEq(t, tc.json, string(bz))
+
+			var decoded SubMsgResult
+			require.NoError(t, json.Unmarshal(bz, &decoded))
+			require.Equal(t, tc.result, decoded)
+		})
+	}
+}
@@ -2,7 +2,10 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
+	"time"
 )
 
 // HumanAddress is a printable (typically bech32 encoded) address string. Just use it as a label for developers.
@@ -58,13 +61,209 @@ func (o OutOfGasError) Error() string {
 	return "Out of gas"
 }
 
+// ErrMemoryConfigInvalid is returned when the memory settings of a VMConfig are inconsistent
+var ErrMemoryConfigInvalid = errors.New("invalid memory config")
+
+// ErrMissingInterfaceVersion is returned when a contract does not export an interface_version_* marker
+var ErrMissingInterfaceVersion = errors.New("contract does not declare an interface version")
+
+// ErrTooManyEvents is returned when a contract response exceeds VMConfig.MaxEventsPerResponse
+var ErrTooManyEvents = errors.New("too many events in contract response")
+
+// ErrTooManyAttributes is returned when a contract response exceeds VMConfig.MaxAttributesPerResponse
+var ErrTooManyAttributes = errors.New("too many attributes in contract response")
+
+// ErrDuplicateEventType is returned when VMConfig.UniqueEventTypes is set and a contract response
+// contains two events of the same type
+var ErrDuplicateEventType = errors.New("duplicate event type in contract response")
+
+// ErrInsufficientContractBalance is returned when a contract response sends more funds than the contract holds
+var ErrInsufficientContractBalance = errors.New("insufficient contract balance")
+
+// ErrNoVariantInfo is returned when a contract's code does not contain the "cw_execute_schema" custom section
+// declaring which message variants it accepts
+var ErrNoVariantInfo = errors.New("contract does not declare its message variants")
+
+// ErrReplyErrCodeDisabled is returned by VM.Reply for a reply with SubMsgResult.ErrCode set
+// unless VMConfig.ReplyErrorCodes is enabled
+var ErrReplyErrCodeDisabled = errors.New("reply error codes are not enabled")
+
+// ErrCodeNotFound is returned when no code is stored for a checksum
+var ErrCodeNotFound = errors.New("code not found")
+
+// ErrCodePinned is returned when trying to remove code that is still pinned
+var ErrCodePinned = errors.New("code is pinned")
+
+// ErrChecksumMismatch is returned when code does not hash to the expected checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrInternalPanic is returned when a panic was caught in the Rust code.
+// Message contains the error message returned by libwasmvm, which is always "Caught panic".
+// The panic output is not captured: libwasmvm has no hook to forward it, so it is still only
+// printed to stderr by the Rust panic handler. Error returns Message unchanged.
+type ErrInternalPanic struct {
+	Message string
+}
+
+var _ error = ErrInternalPanic{}
+
+func (e ErrInternalPanic) Error() string {
+	return e.Message
+}
+
+// VMError is returned when libwasmvm reports an error of the VM, e.g. a failed validation, a cache
+// error or a trap during execution. Msg contains the message returned by libwasmvm.
+type VMError struct {
+	Msg string
+}
+
+var _ error = VMError{}
+
+func (e VMError) Error() string {
+	return e.Msg
+}
+
+// ErrContractPanic is returned when the contract panicked, i.e. called abort. Any changes the contract
+// made to the store are not rolled back by the VM, but queries never write.
+// Msg contains the message returned by libwasmvm.
+type ErrContractPanic struct {
+	Msg string
+}
+
+var _ error = ErrContractPanic{}
+
+func (e ErrContractPanic) Error() string {
+	return e.Msg
+}
+
+// RegionError is returned when a contract passed an invalid memory region to the VM.
+// Msg contains the message returned by libwasmvm.
+type RegionError struct {
+	Msg string
+}
+
+var _ error = RegionError{}
+
+func (e RegionError) Error() string {
+	return e.Msg
+}
+
+// BackendError is returned when a call from the contract into the host (storage, API or querier)
+// failed. Msg contains the message returned by libwasmvm.
+type BackendError struct {
+	Msg string
+}
+
+var _ error = BackendError{}
+
+func (e BackendError) Error() string {
+	return e.Msg
+}
+
+// ErrCacheVersionMismatch is returned when the cache was created by a different libwasmvm version
+// than the one in use. CacheVersion is UnknownCacheVersion for caches that contain compiled modules
+// but no record of the version which created them.
+type ErrCacheVersionMismatch struct {
+	CacheVersion   string
+	LibraryVersion string
+}
+
+// UnknownCacheVersion is the CacheVersion of an ErrCacheVersionMismatch for caches of unknown origin
+const UnknownCacheVersion = "unknown"
+
+var _ error = ErrCacheVersionMismatch{}
+
+func (e ErrCacheVersionMismatch) Error() string {
+	return fmt.Sprintf("cache was created by libwasmvm %s but %s is in use", e.CacheVersion, e.LibraryVersion)
+}
+
+// ExecutionTimeoutError is returned when a contract call exceeded the ExecutionTimeout of its ExecutionOptions
+type ExecutionTimeoutError struct {
+	Timeout time.Duration
+}
+
+var _ error = ExecutionTimeoutError{}
+
+func (e ExecutionTimeoutError) Error() string {
+	return fmt.Sprintf("contract execution exceeded timeout of %s", e.Timeout)
+}
+
+// PinManyError is returned when pinning one of multiple codes failed
+type PinManyError struct {
+	// Checksum is the checksum of the code that could not be pinned
+	Checksum []byte
+	// Pinned is the number of codes pinned successfully before the failure
+	Pinned int
+	Err    error
+}
+
+var _ error = PinManyError{}
+
+func (e PinManyError) Error() string {
+	return fmt.Sprintf("pinning %X failed after %d pinned codes: %s", e.Checksum, e.Pinned, e.Err)
+}
+
+func (e PinManyError) Unwrap() error {
+	return e.Err
+}
+
 // Contains static analysis info of the contract (the Wasm code to be precise).
 // This type is returned by VM.AnalyzeCode().
+// HasUncheckedLoops, EntryPoints and Warnings are computed on a best-effort basis. They are left
+// empty for code that libwasmvm accepts but the analysis in Go cannot parse.
 type AnalysisReport struct {
 	HasIBCEntryPoints bool
 	// Deprecated, use RequiredCapabilities. For now both fields contain the same value.
 	RequiredFeatures     string
 	RequiredCapabilities string
+	// HasUncheckedLoops is true if the code contains a loop that can never be left and that
+	// does not call into the host. Such loops only stop by running out of gas.
+	// This is a heuristic for informational purposes only. The endless loop of the Rust standard
+	// library's abort handler is ignored.
+	HasUncheckedLoops bool
+	// EntryPoints contains the names of the exported entry points in alphabetical order
+	EntryPoints []string
+	// Warnings contains non-fatal findings for reviewing the code, e.g. a large memory.
+	// They do not prevent storing the code.
+	Warnings []string
+}
+
+// MigrateCompat describes if a migration between two codes is possible.
+// This type is returned by VM.CheckMigrateCompatibility().
+type MigrateCompat struct {
+	// HasMigrateEntryPoint is true if the new code can be migrated to
+	HasMigrateEntryPoint bool
+	// OldVersion and NewVersion are the migrate versions declared by the codes, if any
+	OldVersion *uint64
+	NewVersion *uint64
+	// Downgrade is true if both codes declare a migrate version and the new one is lower
+	Downgrade bool
+}
+
+// AuditEntry records one call of a contract entry point. It is passed to VMConfig.AuditSink.
+type AuditEntry struct {
+	Checksum []byte
+	// EntryPoint is the name of the called export, e.g. "execute" or "ibc_packet_receive"
+	EntryPoint string
+	// Sender is the sender of the message for instantiate and execute and empty otherwise
+	Sender  string
+	GasUsed uint64
+	// Err is the error message of a failed call and empty on success
+	Err string
+}
+
+// Success returns true if the call did not fail
+func (e AuditEntry) Success() bool {
+	return e.Err == ""
+}
+
+// BuildInfo describes the libwasmvm build linked into the binary. This type is returned by LibwasmvmBuildInfo().
+type BuildInfo struct {
+	// Version is the version reported by the loaded library
+	Version string
+	// Target is the Rust target triple of the library the Go code links against, e.g.
+	// "x86_64-unknown-linux-gnu". It is empty when linking a system library (sys_wasmvm build tag).
+	Target string
 }
 
 type Metrics struct {
@@ -79,3 +278,43 @@ type Metrics struct {
 	// Cumulative size of all elements in memory cache (in bytes)
 	SizeMemoryCache uint64
 }
+
+// ColdStartReport is the result of VM.ColdStartCost
+type ColdStartReport struct {
+	// ColdGas and WarmGas are the gas used by instantiating without and with a cached module.
+	// libwasmvm does not charge gas for compiling or loading modules, so they only differ if the
+	// contract itself behaves differently.
+	ColdGas uint64
+	WarmGas uint64
+	// ColdDuration and WarmDuration are the wall-clock durations of the instantiations. Their
+	// difference is the extra time needed for compiling the module.
+	ColdDuration time.Duration
+	WarmDuration time.Duration
+	// ColdMetrics and WarmMetrics are the metrics of the temporary cache after the respective
+	// instantiation, which show if the module was compiled or served from a cache
+	ColdMetrics Metrics
+	WarmMetrics Metrics
+}
+
+// EffectiveCacheConfig contains the configured cache sizes of a VM. This type is returned by VM.EffectiveCacheConfig().
+type EffectiveCacheConfig struct {
+	// MemoryCacheSize is the size of the in-memory cache of compiled modules in bytes
+	MemoryCacheSize uint64
+	// InstanceMemoryLimit is the memory limit per contract instance in bytes
+	InstanceMemoryLimit uint64
+}
+
+// CacheLayout describes the on-disk structure of the cache. This type is returned by VM.DescribeCache().
+type CacheLayout struct {
+	BaseDir string
+	// WasmDir contains the original Wasm code, one file per checksum
+	WasmDir string
+	// ModulesDir contains the compiled modules, in a subdirectory per module format version
+	ModulesDir string
+	WasmFiles  uint64
+	// Cumulative size of all files in WasmDir (in bytes)
+	WasmSize    uint64
+	ModuleFiles uint64
+	// Cumulative size of all files in ModulesDir (in bytes)
+	ModulesSize uint64
+}
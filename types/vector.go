@@ -0,0 +1,52 @@
+package types
+
+// ExecutionVector is a recorded contract execution that can be replayed to reproduce
+// the result, e.g. in tests. It is created by VM.ExecuteAndRecord and serializable to JSON.
+type ExecutionVector struct {
+	Checksum []byte `json:"checksum"`
+	// Env, Info and Msg are the JSON encoded inputs of the execute call
+	Env       []byte    `json:"env"`
+	Info      []byte    `json:"info"`
+	Msg       []byte    `json:"msg"`
+	GasLimit  uint64    `json:"gas_limit"`
+	DeserCost UFraction `json:"deser_cost"`
+
+	// State contains the store entries the contract read before writing them, sorted by key.
+	// Keys that were read but did not exist are not included.
+	State []VectorKV `json:"state"`
+	// Queries contains the results of all queries made by the contract
+	Queries []VectorQuery `json:"queries"`
+	// Canonicalize and Humanize contain the results of the address conversions made by the contract
+	Canonicalize []VectorAddress `json:"canonicalize"`
+	Humanize     []VectorAddress `json:"humanize"`
+	// HostCallCounts is the number of calls into the host per type as in ExecuteOutput.HostCallCounts
+	HostCallCounts map[string]uint64 `json:"host_call_counts"`
+
+	// Response, GasUsed and Error contain the result of the execution
+	Response *Response `json:"response,omitempty"`
+	GasUsed  uint64    `json:"gas_used"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// VectorKV is a store entry in an ExecutionVector
+type VectorKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// VectorQuery is a query made by the contract in an ExecutionVector
+type VectorQuery struct {
+	// Request is the JSON encoded QueryRequest
+	Request []byte        `json:"request"`
+	Result  QuerierResult `json:"result"`
+}
+
+// VectorAddress is an address conversion made by the contract in an ExecutionVector
+type VectorAddress struct {
+	Human     string `json:"human"`
+	Canonical []byte `json:"canonical"`
+	Cost      uint64 `json:"cost"`
+	Error     string `json:"error,omitempty"`
+	// Rejected is true if the address was rejected with Error by GoAPI.AddressPreCheck
+	Rejected bool `json:"rejected,omitempty"`
+}
@@ -0,0 +1,319 @@
+package cosmwasm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	dbm "github.com/tendermint/tm-db"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+// ExecuteAndRecord works like Execute but records the inputs, everything the contract read from
+// the host and the result into an ExecutionVector. The vector can be replayed with ReplayVector.
+// In case of an error, the vector contains the error message.
+func (vm *VM) ExecuteAndRecord(
+	checksum Checksum,
+	env types.Env,
+	info types.MessageInfo,
+	executeMsg []byte,
+	store KVStore,
+	goapi GoAPI,
+	querier Querier,
+	gasMeter GasMeter,
+	gasLimit uint64,
+	deserCost types.UFraction,
+) (*types.ExecutionVector, error) {
+	envBin, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	infoBin, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	recorder := newVectorRecorder()
+	store, goapi, querier = recorder.wrap(store, goapi, querier)
+	counter := newHostCallCounter()
+	store, goapi, querier = counter.wrap(store, goapi, querier)
+	res, gasUsed, _, err := vm.executeEncoded(checksum, envBin, infoBin, executeMsg, store, goapi, querier, gasMeter, gasLimit, deserCost)
+
+	vector := types.ExecutionVector{
+		Checksum:       checksum,
+		Env:            envBin,
+		Info:           infoBin,
+		Msg:            executeMsg,
+		GasLimit:       gasLimit,
+		DeserCost:      deserCost,
+		State:          recorder.State(),
+		Queries:        recorder.queries,
+		Canonicalize:   recorder.canonicalize,
+		Humanize:       recorder.humanize,
+		HostCallCounts: counter.Counts(),
+		Response:       res,
+		GasUsed:        gasUsed,
+	}
+	if err != nil {
+		vector.Error = err.Error()
+	}
+	return &vector, err
+}
+
+// ReplayVector executes the contract again with the inputs and host data of the given vector and
+// returns an error describing all differences to the recorded result. The code must be stored in vm.
+func ReplayVector(vm *VM, vector *types.ExecutionVector) error {
+	store := api.NewLookup(api.NewMockGasMeter(compareGasLimit))
+	for _, kv := range vector.State {
+		store.Set(kv.Key, kv.Value)
+	}
+	gasMeter := api.NewMockGasMeter(vector.GasLimit)
+	store.SetGasMeter(gasMeter)
+	goapi := replayAPI(vector)
+	var querier Querier = newReplayQuerier(vector)
+
+	counter := newHostCallCounter()
+	wrappedStore, goapi, querier := counter.wrap(store, goapi, querier)
+	res, gasUsed, _, err := vm.executeEncoded(vector.Checksum, vector.Env, vector.Info, vector.Msg, wrappedStore, goapi, querier, gasMeter, vector.GasLimit, vector.DeserCost)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	var diffs []string
+	if gasUsed != vector.GasUsed {
+		diffs = append(diffs, fmt.Sprintf("gas used %d != %d", gasUsed, vector.GasUsed))
+	}
+	if !types.ResponsesEqual(res, vector.Response) {
+		diffs = append(diffs, "response differs")
+	}
+	if errMsg != vector.Error {
+		diffs = append(diffs, fmt.Sprintf("error %q != %q", errMsg, vector.Error))
+	}
+	if counts := counter.Counts(); !reflect.DeepEqual(counts, vector.HostCallCounts) {
+		diffs = append(diffs, fmt.Sprintf("host call counts %v != %v", counts, vector.HostCallCounts))
+	}
+	if len(diffs) != 0 {
+		return fmt.Errorf("replay differs:\n%s", strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+// vectorRecorder collects the host data of an execution for an ExecutionVector
+type vectorRecorder struct {
+	// state contains the first value read for each key that was not written before
+	state map[string][]byte
+	// touched contains all keys that were read or written
+	touched      map[string]bool
+	queries      []types.VectorQuery
+	canonicalize []types.VectorAddress
+	humanize     []types.VectorAddress
+}
+
+func newVectorRecorder() *vectorRecorder {
+	return &vectorRecorder{
+		state:   make(map[string][]byte),
+		touched: make(map[string]bool),
+	}
+}
+
+func (r *vectorRecorder) read(key, value []byte) {
+	if r.touched[string(key)] {
+		return
+	}
+	r.touched[string(key)] = true
+	if value != nil {
+		r.state[string(key)] = bytes.Clone(value)
+	}
+}
+
+func (r *vectorRecorder) write(key []byte) {
+	r.touched[string(key)] = true
+}
+
+// State returns the recorded store entries sorted by key
+func (r *vectorRecorder) State() []types.VectorKV {
+	out := make([]types.VectorKV, 0, len(r.state))
+	for key, value := range r.state {
+		out = append(out, types.VectorKV{Key: []byte(key), Value: value})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return bytes.Compare(out[i].Key, out[j].Key) < 0
+	})
+	return out
+}
+
+// wrap returns versions of the given store, API and querier that record what the contract read
+func (r *vectorRecorder) wrap(store KVStore, goapi GoAPI, querier Querier) (KVStore, GoAPI, Querier) {
+	recordingAPI := GoAPI{
+		HumanAddress: func(canon []byte) (string, uint64, error) {
+			human, cost, err := goapi.HumanAddress(canon)
+			r.humanize = append(r.humanize, newVectorAddress(human, canon, cost, err))
+			return human, cost, err
+		},
+		CanonicalAddress: func(human string) ([]byte, uint64, error) {
+			canon, cost, err := goapi.CanonicalAddress(human)
+			r.canonicalize = append(r.canonicalize, newVectorAddress(human, canon, cost, err))
+			return canon, cost, err
+		},
+	}
+	if goapi.AddressPreCheck != nil {
+		recordingAPI.AddressPreCheck = func(human string) error {
+			err := goapi.AddressPreCheck(human)
+			if err != nil {
+				address := newVectorAddress(human, nil, 0, err)
+				address.Rejected = true
+				r.canonicalize = append(r.canonicalize, address)
+			}
+			return err
+		}
+	}
+	return recordingStore{store, r}, recordingAPI, recordingQuerier{querier, r}
+}
+
+func newVectorAddress(human string, canon []byte, cost uint64, err error) types.VectorAddress {
+	address := types.VectorAddress{Human: human, Canonical: canon, Cost: cost}
+	if err != nil {
+		address.Error = err.Error()
+	}
+	return address
+}
+
+type recordingStore struct {
+	KVStore
+	recorder *vectorRecorder
+}
+
+func (s recordingStore) Get(key []byte) []byte {
+	value := s.KVStore.Get(key)
+	s.recorder.read(key, value)
+	return value
+}
+
+func (s recordingStore) Set(key, value []byte) {
+	s.recorder.write(key)
+	s.KVStore.Set(key, value)
+}
+
+func (s recordingStore) Delete(key []byte) {
+	s.recorder.write(key)
+	s.KVStore.Delete(key)
+}
+
+func (s recordingStore) Iterator(start, end []byte) dbm.Iterator {
+	return recordingIterator{s.KVStore.Iterator(start, end), s.recorder}
+}
+
+func (s recordingStore) ReverseIterator(start, end []byte) dbm.Iterator {
+	return recordingIterator{s.KVStore.ReverseIterator(start, end), s.recorder}
+}
+
+type recordingIterator struct {
+	dbm.Iterator
+	recorder *vectorRecorder
+}
+
+func (i recordingIterator) Value() []byte {
+	value := i.Iterator.Value()
+	i.recorder.read(i.Iterator.Key(), value)
+	return value
+}
+
+type recordingQuerier struct {
+	types.Querier
+	recorder *vectorRecorder
+}
+
+func (q recordingQuerier) Query(request types.QueryRequest, gasLimit uint64) ([]byte, error) {
+	res, err := q.Querier.Query(request, gasLimit)
+	requestBin, _ := json.Marshal(request)
+	q.recorder.queries = append(q.recorder.queries, types.VectorQuery{
+		Request: requestBin,
+		Result:  types.ToQuerierResult(res, err),
+	})
+	return res, err
+}
+
+// replayAPI returns an API answering with the address conversions recorded in vector
+func replayAPI(vector *types.ExecutionVector) GoAPI {
+	toResult := func(address types.VectorAddress) error {
+		if address.Error != "" {
+			return errors.New(address.Error)
+		}
+		return nil
+	}
+	return GoAPI{
+		HumanAddress: func(canon []byte) (string, uint64, error) {
+			for _, address := range vector.Humanize {
+				if bytes.Equal(address.Canonical, canon) {
+					return address.Human, address.Cost, toResult(address)
+				}
+			}
+			return "", 0, fmt.Errorf("address %X not in vector", canon)
+		},
+		CanonicalAddress: func(human string) ([]byte, uint64, error) {
+			for _, address := range vector.Canonicalize {
+				if address.Human == human && !address.Rejected {
+					return address.Canonical, address.Cost, toResult(address)
+				}
+			}
+			return nil, 0, fmt.Errorf("address %q not in vector", human)
+		},
+		AddressPreCheck: func(human string) error {
+			for _, address := range vector.Canonicalize {
+				if address.Human == human && address.Rejected {
+					return toResult(address)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// replayQuerier answers queries with the results recorded in an ExecutionVector
+type replayQuerier struct {
+	// results contains the outstanding results by JSON encoded request in recording order
+	results map[string][]types.QuerierResult
+}
+
+var _ types.Querier = &replayQuerier{}
+
+func newReplayQuerier(vector *types.ExecutionVector) *replayQuerier {
+	results := make(map[string][]types.QuerierResult)
+	for _, query := range vector.Queries {
+		results[string(query.Request)] = append(results[string(query.Request)], query.Result)
+	}
+	return &replayQuerier{results: results}
+}
+
+func (q *replayQuerier) Query(request types.QueryRequest, _gasLimit uint64) ([]byte, error) {
+	requestBin, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	results := q.results[string(requestBin)]
+	if len(results) == 0 {
+		return nil, fmt.Errorf("query %s not in vector", requestBin)
+	}
+	result := results[0]
+	q.results[string(requestBin)] = results[1:]
+	switch {
+	case result.Err != nil:
+		return nil, *result.Err
+	case result.Ok == nil:
+		return nil, fmt.Errorf("empty result for query %s", requestBin)
+	case result.Ok.Err != "":
+		return nil, errors.New(result.Ok.Err)
+	default:
+		return result.Ok.Ok, nil
+	}
+}
+
+func (q *replayQuerier) GasConsumed() uint64 {
+	return 0
+}
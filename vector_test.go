@@ -0,0 +1,87 @@
+package cosmwasm
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Finschia/wasmvm/internal/api"
+	"github.com/Finschia/wasmvm/types"
+)
+
+func TestExecuteAndRecord(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, types.Coins{types.NewCoin(250, "ATOM")})
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+	msg := []byte(`{"verifier": "fred", "beneficiary": "bob"}`)
+	_, _, err := vm.Instantiate(checksum, env, info, msg, store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+
+	info = api.MockInfo("fred", nil)
+	vector, err := vm.ExecuteAndRecord(checksum, env, info, []byte(`{"release":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Len(t, vector.State, 1)
+	require.Len(t, vector.Queries, 1)
+	require.NotNil(t, vector.Response)
+	require.Equal(t, 1, len(vector.Response.Messages))
+
+	// round trip through JSON
+	bz, err := json.Marshal(vector)
+	require.NoError(t, err)
+	var loaded types.ExecutionVector
+	err = json.Unmarshal(bz, &loaded)
+	require.NoError(t, err)
+
+	// replay on a fresh VM
+	other := withVM(t)
+	createTestContract(t, other, HACKATOM_TEST_CONTRACT)
+	err = ReplayVector(other, &loaded)
+	require.NoError(t, err)
+
+	// a different message does not reproduce the result
+	loaded.Msg = []byte(`{"panic":{}}`)
+	err = ReplayVector(other, &loaded)
+	require.ErrorContains(t, err, "replay differs")
+}
+
+func TestExecuteAndRecordAddressPreCheck(t *testing.T) {
+	vm := withVM(t)
+	checksum := createTestContract(t, vm, HACKATOM_TEST_CONTRACT)
+
+	deserCost := types.UFraction{Numerator: 1, Denominator: 1}
+	gasMeter := api.NewMockGasMeter(TESTING_GAS_LIMIT)
+	store := api.NewLookup(gasMeter)
+	goapi := api.NewMockAPI()
+	// a chain using 0x prefixed addresses
+	goapi.AddressPreCheck = func(human string) error {
+		if !strings.HasPrefix(human, "0x") {
+			return errors.New("missing 0x prefix")
+		}
+		return nil
+	}
+	querier := api.DefaultQuerier(api.MOCK_CONTRACT_ADDR, nil)
+	env := api.MockEnv()
+	info := api.MockInfo("creator", nil)
+
+	// the contract canonicalizes an invalid address and expects an error
+	vector, err := vm.ExecuteAndRecord(checksum, env, info, []byte(`{"user_errors_in_api_calls":{}}`), store, *goapi, querier, gasMeter, TESTING_GAS_LIMIT, deserCost)
+	require.NoError(t, err)
+	require.Len(t, vector.Canonicalize, 1)
+	require.True(t, vector.Canonicalize[0].Rejected)
+	require.Equal(t, "missing 0x prefix", vector.Canonicalize[0].Error)
+
+	other := withVM(t)
+	createTestContract(t, other, HACKATOM_TEST_CONTRACT)
+	err = ReplayVector(other, vector)
+	require.NoError(t, err)
+}